@@ -0,0 +1,46 @@
+package url
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// defaultReconnectMinDelay and defaultReconnectMaxDelay bound how long
+	// Run waits before reconnecting to seabird-core.
+	defaultReconnectMinDelay = time.Second
+	defaultReconnectMaxDelay = 2 * time.Minute
+
+	// reconnectResetAfter is how long a connection needs to stay up before
+	// it's considered healthy and the delay goes back to the minimum.
+	reconnectResetAfter = time.Minute
+)
+
+// backoff calculates exponentially increasing delays with jitter.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt int
+}
+
+// next returns the delay before the next attempt. Each delay is somewhere
+// between half and all of the current exponential step, so multiple clients
+// don't all retry at the same time.
+func (b *backoff) next() time.Duration {
+	step := b.min
+	for i := 0; i < b.attempt && step < b.max; i++ {
+		step *= 2
+	}
+	step = min(step, b.max)
+
+	b.attempt++
+
+	half := step / 2
+
+	return half + rand.N(step-half+1)
+}
+
+// reset goes back to the minimum delay.
+func (b *backoff) reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,21 @@
+package url
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: time.Second, max: 10 * time.Second}
+
+	for _, step := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		delay := b.next()
+		require.GreaterOrEqual(t, delay, step*time.Second/2)
+		require.LessOrEqual(t, delay, step*time.Second)
+	}
+
+	b.reset()
+	require.LessOrEqual(t, b.next(), time.Second)
+}
@@ -0,0 +1,280 @@
+package url
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is how long a preview is cached if the provider doesn't
+	// have its own TTL.
+	DefaultCacheTTL = time.Hour
+
+	// DefaultCacheNegativeTTL is how long a URL which couldn't be previewed
+	// is remembered.
+	DefaultCacheNegativeTTL = 5 * time.Minute
+
+	// DefaultCacheMaxEntries is the maximum size of the in-memory cache.
+	DefaultCacheMaxEntries = 1000
+)
+
+// Cache backends which can be selected in CacheConfig.
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendStore  = "store"
+	CacheBackendNone   = "none"
+)
+
+// CacheConfig controls how previews are cached.
+type CacheConfig struct {
+	// Backend is one of "memory" (the default), "store" to keep the cache in
+	// the on-disk Store, or "none" to disable caching.
+	Backend string
+
+	// MaxEntries limits the size of the in-memory cache. It defaults to
+	// DefaultCacheMaxEntries.
+	MaxEntries int
+
+	// TTL is how long previews are cached. It defaults to DefaultCacheTTL.
+	TTL time.Duration
+
+	// NegativeTTL is how long URLs which couldn't be previewed (because they
+	// weren't found or the lookup failed) are cached. It defaults to
+	// DefaultCacheNegativeTTL.
+	NegativeTTL time.Duration
+
+	// ProviderTTLs overrides TTL for specific providers. Provider names are
+	// matched case-insensitively.
+	ProviderTTLs map[string]time.Duration
+}
+
+// CacheEntry is a single cached lookup result. A nil Preview means there was
+// nothing to display for the URL.
+type CacheEntry struct {
+	Preview *Preview
+	Expires time.Time
+}
+
+// PreviewCache stores the results of URL lookups. Implementations must be
+// safe for concurrent use and must not return expired entries.
+type PreviewCache interface {
+	Get(key string) (CacheEntry, bool)
+	Set(key string, entry CacheEntry)
+}
+
+// cacheKey normalizes a URL so trivially different forms of the same link
+// share a cache entry.
+func cacheKey(u *url.URL) string {
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+
+	key := strings.ToLower(u.Scheme) + "://" + host + strings.TrimRight(u.EscapedPath(), "/")
+
+	// Encode sorts the query by key.
+	if query := u.Query().Encode(); query != "" {
+		key += "?" + query
+	}
+
+	return key
+}
+
+// clonePreview returns a copy of p so cached previews can't be modified by
+// callers.
+func clonePreview(p *Preview) *Preview {
+	if p == nil {
+		return nil
+	}
+
+	ret := *p
+	ret.Fields = append([]PreviewField(nil), p.Fields...)
+	ret.Annotations = append([]string(nil), p.Annotations...)
+
+	return &ret
+}
+
+// MemoryCache is a PreviewCache which keeps entries in memory. When full, it
+// drops expired entries first and then the entries closest to expiring.
+type MemoryCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	entries    map[string]CacheEntry
+}
+
+// NewMemoryCache returns a MemoryCache holding at most maxEntries entries.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]CacheEntry),
+	}
+}
+
+func (c *MemoryCache) Get(key string) (CacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+
+	if time.Now().After(entry.Expires) {
+		delete(c.entries, key)
+		return CacheEntry{}, false
+	}
+
+	entry.Preview = clonePreview(entry.Preview)
+
+	return entry, true
+}
+
+func (c *MemoryCache) Set(key string, entry CacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+
+	entry.Preview = clonePreview(entry.Preview)
+	c.entries[key] = entry
+}
+
+// evict makes room for at least one entry. It must be called with the lock
+// held.
+func (c *MemoryCache) evict() {
+	now := time.Now()
+
+	var (
+		oldestKey     string
+		oldestExpires time.Time
+	)
+
+	for key, entry := range c.entries {
+		if now.After(entry.Expires) {
+			delete(c.entries, key)
+			continue
+		}
+
+		if oldestKey == "" || entry.Expires.Before(oldestExpires) {
+			oldestKey = key
+			oldestExpires = entry.Expires
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// storeCacheBucket is the Store bucket used by StoreCache.
+const storeCacheBucket = "preview-cache"
+
+// storeCachePruneInterval is how many writes happen between sweeps for
+// expired entries.
+const storeCachePruneInterval = 100
+
+// StoreCache is a PreviewCache which keeps entries in a Store, so they
+// survive restarts.
+type StoreCache struct {
+	store  *Store
+	writes atomic.Int64
+}
+
+// NewStoreCache returns a StoreCache backed by the given Store.
+func NewStoreCache(store *Store) *StoreCache {
+	c := &StoreCache{store: store}
+	c.prune()
+
+	return c
+}
+
+func (c *StoreCache) Get(key string) (CacheEntry, bool) {
+	var entry CacheEntry
+
+	ok, err := c.store.get(storeCacheBucket, key, &entry)
+	if err != nil {
+		log.Printf("Failed to read cached preview for %s: %s", key, err)
+		return CacheEntry{}, false
+	}
+
+	if !ok || time.Now().After(entry.Expires) {
+		return CacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+func (c *StoreCache) Set(key string, entry CacheEntry) {
+	if err := c.store.put(storeCacheBucket, key, entry); err != nil {
+		log.Printf("Failed to cache preview for %s: %s", key, err)
+	}
+
+	if c.writes.Add(1)%storeCachePruneInterval == 0 {
+		c.prune()
+	}
+}
+
+// prune removes all expired entries.
+func (c *StoreCache) prune() {
+	now := time.Now()
+
+	err := c.store.deleteWhere(storeCacheBucket, func(key string, data []byte) bool {
+		var entry CacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return true
+		}
+
+		return now.After(entry.Expires)
+	})
+	if err != nil {
+		log.Printf("Failed to prune preview cache: %s", err)
+	}
+}
+
+// cachePreview stores the result of looking up a URL, using the TTL for the
+// provider which generated it. Previews which were already sent by a legacy
+// callback are never cached because there would be nothing to send on a hit.
+func (c *Client) cachePreview(key string, preview *Preview) {
+	if c.cache == nil {
+		return
+	}
+
+	if preview != nil && preview.sent {
+		return
+	}
+
+	ttl := c.cacheConfig.NegativeTTL
+	if preview != nil && preview.Err == nil {
+		ttl = c.cacheConfig.TTL
+		if providerTTL, ok := c.cacheConfig.ProviderTTLs[strings.ToLower(preview.Provider)]; ok {
+			ttl = providerTTL
+		}
+	} else {
+		// Failed lookups are cached as "nothing to display".
+		preview = nil
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	c.cache.Set(key, CacheEntry{
+		Preview: preview,
+		Expires: time.Now().Add(ttl),
+	})
+}
+
+// SetCache replaces the cache used for previews. This can be used to plug in
+// a different backend. Passing nil disables caching. It must be called
+// before Run.
+func (c *Client) SetCache(cache PreviewCache) {
+	c.cache = cache
+}
@@ -0,0 +1,134 @@
+package url
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheKey(t *testing.T) {
+	var tests = []struct {
+		a, b string
+	}{
+		{"https://github.com/foo/bar", "https://GitHub.com/foo/bar/"},
+		{"https://www.github.com/foo/bar", "https://github.com/foo/bar"},
+		{"https://example.com/?a=1&b=2", "https://example.com/?b=2&a=1"},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, cacheKey(mustParseURL(t, test.a)), cacheKey(mustParseURL(t, test.b)))
+	}
+
+	require.NotEqual(t,
+		cacheKey(mustParseURL(t, "https://github.com/foo/bar")),
+		cacheKey(mustParseURL(t, "https://github.com/foo/baz")))
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := NewMemoryCache(2)
+	now := time.Now()
+
+	cache.Set("expired", CacheEntry{Preview: &Preview{Title: "expired"}, Expires: now.Add(-time.Second)})
+	_, ok := cache.Get("expired")
+	require.False(t, ok)
+
+	cache.Set("a", CacheEntry{Preview: &Preview{Title: "a"}, Expires: now.Add(time.Minute)})
+	cache.Set("b", CacheEntry{Preview: &Preview{Title: "b"}, Expires: now.Add(time.Hour)})
+	cache.Set("c", CacheEntry{Expires: now.Add(time.Hour)})
+
+	// "a" was closest to expiring, so it should have been evicted.
+	_, ok = cache.Get("a")
+	require.False(t, ok)
+
+	entry, ok := cache.Get("b")
+	require.True(t, ok)
+	require.Equal(t, "b", entry.Preview.Title)
+
+	// Modifying a returned preview shouldn't affect the cache.
+	entry.Preview.Title = "modified"
+	entry, _ = cache.Get("b")
+	require.Equal(t, "b", entry.Preview.Title)
+
+	entry, ok = cache.Get("c")
+	require.True(t, ok)
+	require.Nil(t, entry.Preview)
+}
+
+func TestStoreCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+
+	store, err := OpenStore(path)
+	require.NoError(t, err)
+
+	cache := NewStoreCache(store)
+	cache.Set("key", CacheEntry{
+		Preview: &Preview{
+			Provider: "Test",
+			Title:    "title",
+			Fields:   []PreviewField{{Name: "id", Value: "1"}},
+		},
+		Expires: time.Now().Add(time.Hour),
+	})
+	cache.Set("expired", CacheEntry{Expires: time.Now().Add(-time.Second)})
+	require.NoError(t, store.Close())
+
+	// Entries should survive reopening the store.
+	store, err = OpenStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	cache = NewStoreCache(store)
+
+	entry, ok := cache.Get("key")
+	require.True(t, ok)
+	require.Equal(t, "[Test] title", renderPreview(entry.Preview))
+	require.Equal(t, "1", entry.Preview.Field("id"))
+
+	_, ok = cache.Get("expired")
+	require.False(t, ok)
+}
+
+func TestLookupURLCache(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{
+		"/handled": "page.html",
+	})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, _ := newTestClient(t)
+	p := &testProvider{host: host}
+	c.Register(p)
+
+	var err error
+	c.cacheConfig, c.cache, err = newCache(CacheConfig{
+		ProviderTTLs: map[string]time.Duration{"test": time.Minute},
+	}, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		preview := c.lookupURL(testSource, server.URL+"/handled/")
+		require.NotNil(t, preview)
+		require.Equal(t, "[Test] handled", renderPreview(preview))
+	}
+	require.Equal(t, int64(1), p.calls.Load())
+
+	// Failures are cached as negative entries.
+	c.quietOnError = true
+	for i := 0; i < 2; i++ {
+		require.Nil(t, c.lookupURL(testSource, server.URL+"/failed"))
+	}
+	require.Equal(t, int64(2), p.calls.Load())
+
+	// A TTL of zero disables caching for that provider.
+	c.cacheConfig.ProviderTTLs["test"] = 0
+	c.cache = NewMemoryCache(0)
+	for i := 0; i < 2; i++ {
+		require.NotNil(t, c.lookupURL(testSource, server.URL+"/handled"))
+	}
+	require.Equal(t, int64(4), p.calls.Load())
+
+	_, _, err = newCache(CacheConfig{Backend: CacheBackendStore}, nil)
+	require.Error(t, err)
+}
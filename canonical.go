@@ -0,0 +1,167 @@
+package url
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// trackingParams are query parameters which only exist to track where a link
+// was shared from, so they're dropped from every URL.
+var trackingParams = map[string]bool{
+	"fbclid":      true,
+	"gclid":       true,
+	"dclid":       true,
+	"gbraid":      true,
+	"wbraid":      true,
+	"msclkid":     true,
+	"yclid":       true,
+	"twclid":      true,
+	"ttclid":      true,
+	"igshid":      true,
+	"mc_cid":      true,
+	"mc_eid":      true,
+	"mkt_tok":     true,
+	"_hsenc":      true,
+	"_hsmi":       true,
+	"ref_src":     true,
+	"oly_anon_id": true,
+	"oly_enc_id":  true,
+	"vero_id":     true,
+}
+
+// siteTrackingParams are tracking parameters which only mean that on specific
+// sites, keyed by domain without any "www.". On other sites they may well be
+// important.
+var siteTrackingParams = map[string]map[string]bool{
+	"youtube.com":       {"si": true, "feature": true, "pp": true},
+	"music.youtube.com": {"si": true, "feature": true},
+	"youtu.be":          {"si": true, "feature": true},
+	"open.spotify.com":  {"si": true},
+	"twitter.com":       {"s": true, "t": true},
+	"x.com":             {"s": true, "t": true},
+	"instagram.com":     {"igsh": true},
+}
+
+// defaultPorts are dropped from hosts, since they don't change anything.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// canonicalizeURL rewrites a URL into the form used to identify it, so
+// different ways of writing the same link are treated the same by providers,
+// the cache, repost detection and the history. Pages are still fetched from
+// the link as it was posted. The scheme and host are lowercased, default
+// ports are dropped, internationalized hosts are converted to ASCII, tracking
+// parameters and fragments are removed and short YouTube links are expanded.
+func canonicalizeURL(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = canonicalHost(u.Scheme, u.Host)
+
+	// Fragments are never sent to servers, so they don't change what a link
+	// points at.
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// youtu.be/<id> is the same video as youtube.com/watch?v=<id>. Only the
+	// video ID is moved, so any other parameters, like a start time, are
+	// kept.
+	switch u.Host {
+	case "youtu.be":
+		if id := strings.Trim(u.Path, "/"); id != "" && !strings.Contains(id, "/") {
+			query := "v=" + url.QueryEscape(id)
+			if u.RawQuery != "" {
+				query += "&" + u.RawQuery
+			}
+
+			u.Host = "www.youtube.com"
+			u.Path = "/watch"
+			u.RawPath = ""
+			u.RawQuery = query
+		}
+	case "m.youtube.com":
+		u.Host = "www.youtube.com"
+	}
+
+	u.RawQuery = stripTrackingParams(normalizeDomain(u.Hostname()), u.RawQuery)
+	u.ForceQuery = false
+
+	// Strip the last character if it's a slash
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+}
+
+// canonicalHost lowercases a host, converts it to ASCII if it's an
+// internationalized domain name and drops the port if it's the default for
+// the scheme.
+func canonicalHost(scheme, host string) string {
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+	hostname = strings.TrimSuffix(strings.ToLower(strings.Trim(hostname, "[]")), ".")
+
+	// Hosts which aren't valid domain names are left alone, rather than
+	// failing the whole lookup.
+	if ascii, err := idna.Lookup.ToASCII(hostname); err == nil {
+		hostname = ascii
+	}
+
+	if port == defaultPorts[scheme] {
+		port = ""
+	}
+
+	switch {
+	case port != "":
+		return net.JoinHostPort(hostname, port)
+	case strings.Contains(hostname, ":"):
+		return "[" + hostname + "]"
+	}
+
+	return hostname
+}
+
+// stripTrackingParams removes any tracking parameters from a raw query,
+// leaving everything else exactly as it was written.
+func stripTrackingParams(domain, rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	site := siteTrackingParams[domain]
+
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		key = strings.ToLower(key)
+
+		if strings.HasPrefix(key, "utm_") || trackingParams[key] || site[key] {
+			continue
+		}
+
+		kept = append(kept, param)
+	}
+
+	return strings.Join(kept, "&")
+}
+
+// canonicalURL returns the canonical form of a raw URL from a message. URLs
+// which can't be parsed are returned as-is.
+func canonicalURL(raw string) string {
+	u, err := parseURL(raw)
+	if err != nil {
+		return raw
+	}
+
+	return u.String()
+}
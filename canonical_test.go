@@ -0,0 +1,101 @@
+package url
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	var tests = []struct {
+		raw, expected string
+	}{
+		{"https://example.com/page", "https://example.com/page"},
+		{"HTTPS://Example.COM/Page/", "https://example.com/Page"},
+		{"https://example.com:443/page", "https://example.com/page"},
+		{"http://example.com:80/page", "http://example.com/page"},
+		{"http://example.com:8080/page", "http://example.com:8080/page"},
+		{"https://example.com./page", "https://example.com/page"},
+		{"https://example.com/page#section", "https://example.com/page"},
+		{"https://example.com/page?id=1#section", "https://example.com/page?id=1"},
+		{"https://bücher.example/", "https://xn--bcher-kva.example"},
+		{"https://[::1]:443/", "https://[::1]"},
+
+		// Tracking parameters are removed, but everything else is left as
+		// it was written.
+		{"https://example.com/?utm_source=x&UTM_Medium=y&id=1", "https://example.com?id=1"},
+		{"https://example.com/?b=2&fbclid=abc&a=1&gclid=def", "https://example.com?b=2&a=1"},
+		{"https://example.com/?q=a%20b&utm_campaign=z", "https://example.com?q=a%20b"},
+		{"https://example.com/?si=1", "https://example.com?si=1"},
+		{"https://open.spotify.com/track/abc?si=123", "https://open.spotify.com/track/abc"},
+		{"https://x.com/user/status/1?s=20&t=abc", "https://x.com/user/status/1"},
+
+		// Short and mobile YouTube links point at the same video.
+		{"https://youtu.be/abc?si=xyz", "https://www.youtube.com/watch?v=abc"},
+		{"https://youtu.be/abc?t=30", "https://www.youtube.com/watch?v=abc&t=30"},
+		{"https://m.youtube.com/watch?v=abc&feature=share", "https://www.youtube.com/watch?v=abc"},
+		{"https://youtu.be/", "https://youtu.be"},
+
+		{"not a url", "not a url"},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, canonicalURL(test.raw), test.raw)
+	}
+}
+
+func TestMessageCallbackCanonicalURLs(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	var (
+		lock   sync.Mutex
+		lookup []string
+	)
+	c.callbacks["www.youtube.com"] = []registeredCallback{{
+		provider: "Test",
+		callback: func(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
+			lock.Lock()
+			defer lock.Unlock()
+
+			lookup = append(lookup, u.String())
+
+			return &Preview{Provider: "Test", Title: "video"}
+		},
+	}}
+
+	// Both links are the same video, so it's only looked up once, and the
+	// provider sees the canonical form.
+	c.messageCallback(testSource, "https://youtu.be/abc?si=xyz https://www.youtube.com/watch?v=abc&feature=share", nil)
+	c.inFlight.Wait()
+
+	require.Equal(t, []string{"https://www.youtube.com/watch?v=abc"}, lookup)
+}
+
+func TestMessageCallbackFetchesPostedURL(t *testing.T) {
+	// The canonical form has no trailing slash, but this site only answers
+	// with one.
+	server := newFixtureServer(t, "title", map[string]string{
+		"/foo/": "page.html",
+	})
+
+	c, fake := newTestClient(t)
+
+	c.history = newHistoryTracker(HistoryConfig{Enabled: true}, newTestStore(t))
+
+	c.messageCallback(testSource, server.URL+"/foo/?utm_source=chat", nil)
+	c.inFlight.Wait()
+
+	require.Len(t, fake.Messages(), 1)
+	require.Equal(t, "Title: Example Domain", strings.Join(strings.Fields(fake.Messages()[0]), " "))
+
+	// Everything else still uses the canonical form.
+	entries, err := c.history.find(testSource.ChannelId, 10, func(*historyEntry) bool { return true })
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, server.URL+"/foo", entries[0].URL)
+}
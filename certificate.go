@@ -0,0 +1,99 @@
+package url
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// scrape makes a request for a user-provided URL. Certificates are verified
+// unless the host is in the insecure list, but if verification fails the
+// request is retried without it so the link can still be previewed. In that
+// case, problem describes what was wrong with the certificate.
+func (c *Client) scrape(req *http.Request) (resp *http.Response, problem string, err error) {
+	return scrapeWith(c.scrapeHTTP, c.insecureScrapeHTTP, c.insecureHosts, req)
+}
+
+// scrapeNoRedirect is like scrape, but returns redirects rather than
+// following them.
+func (c *Client) scrapeNoRedirect(req *http.Request) (resp *http.Response, problem string, err error) {
+	return scrapeWith(noRedirects(c.scrapeHTTP), noRedirects(c.insecureScrapeHTTP), c.insecureHosts, req)
+}
+
+func scrapeWith(secure, insecure *http.Client, insecureHosts []hostPattern, req *http.Request) (resp *http.Response, problem string, err error) {
+	if hostMatches(insecureHosts, req.URL.Hostname()) {
+		resp, err = insecure.Do(req)
+		return resp, "", err
+	}
+
+	resp, err = secure.Do(req)
+
+	problem = certificateProblem(err, time.Now())
+	if problem == "" {
+		return resp, "", err
+	}
+
+	log.Printf("Invalid certificate for %s, retrying without verification: %s", req.URL, err)
+
+	// Requests for user-provided URLs never have a body, so it's safe to send
+	// the same one again.
+	resp, err = insecure.Do(req.Clone(req.Context()))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp, problem, nil
+}
+
+// noRedirects returns a copy of client which doesn't follow redirects.
+func noRedirects(client *http.Client) *http.Client {
+	ret := *client
+	ret.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &ret
+}
+
+// certificateProblem returns a short description of why a request failed
+// certificate verification, or an empty string if it failed for some other
+// reason.
+func certificateProblem(err error, now time.Time) string {
+	var verifyErr *tls.CertificateVerificationError
+	if !errors.As(err, &verifyErr) {
+		return ""
+	}
+
+	var (
+		invalidErr  x509.CertificateInvalidError
+		hostnameErr x509.HostnameError
+		unknownErr  x509.UnknownAuthorityError
+	)
+
+	switch {
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		if invalidErr.Cert != nil && now.Before(invalidErr.Cert.NotBefore) {
+			return "not yet valid"
+		}
+		return "expired"
+	case errors.As(err, &hostnameErr):
+		return "wrong host"
+	case errors.As(err, &unknownErr):
+		if unknownErr.Cert != nil && bytes.Equal(unknownErr.Cert.RawIssuer, unknownErr.Cert.RawSubject) {
+			return "self-signed"
+		}
+		return "unknown issuer"
+	}
+
+	return "invalid"
+}
+
+// certificateAnnotation is added to previews of sites with invalid
+// certificates.
+func certificateAnnotation(problem string) string {
+	return "[invalid certificate: " + problem + "]"
+}
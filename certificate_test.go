@@ -0,0 +1,85 @@
+package url
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateProblem(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	cert := &x509.Certificate{
+		NotBefore:  now.Add(-24 * time.Hour),
+		NotAfter:   now.Add(-time.Hour),
+		RawIssuer:  []byte("issuer"),
+		RawSubject: []byte("subject"),
+	}
+	future := &x509.Certificate{NotBefore: now.Add(time.Hour)}
+	selfSigned := &x509.Certificate{RawIssuer: []byte("self"), RawSubject: []byte("self")}
+
+	verifyErr := func(err error) error {
+		// This is how verification errors come back from an http.Client.
+		return &url.Error{
+			Op:  "Get",
+			URL: "https://example.com",
+			Err: &tls.CertificateVerificationError{Err: err},
+		}
+	}
+
+	var tests = []struct {
+		err      error
+		expected string
+	}{
+		{errors.New("connection refused"), ""},
+		{x509.UnknownAuthorityError{Cert: cert}, ""},
+		{verifyErr(x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired}), "expired"},
+		{verifyErr(x509.CertificateInvalidError{Cert: future, Reason: x509.Expired}), "not yet valid"},
+		{verifyErr(x509.HostnameError{Certificate: cert, Host: "example.com"}), "wrong host"},
+		{verifyErr(x509.UnknownAuthorityError{Cert: cert}), "unknown issuer"},
+		{verifyErr(x509.UnknownAuthorityError{Cert: selfSigned}), "self-signed"},
+		{verifyErr(x509.CertificateInvalidError{Cert: cert, Reason: x509.NotAuthorizedToSign}), "invalid"},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, certificateProblem(test.err, now), fmt.Sprint(test.err))
+	}
+}
+
+func TestDefaultLinkProviderInvalidCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head><title>Self-signed</title></head></html>"))
+	}))
+	defer server.Close()
+
+	c, _ := newTestClient(t)
+
+	// The test server's certificate isn't trusted, so the page is fetched
+	// again without verification and the preview is marked.
+	preview := c.defaultLinkProvider(context.Background(), server.URL)
+	require.NotNil(t, preview)
+	require.Equal(t, "Title: Self-signed [invalid certificate: self-signed]", renderPreview(preview))
+
+	// Insecure hosts are never verified, so there's nothing to mark.
+	c.insecureHosts = []hostPattern{{host: "127.0.0.1"}}
+	preview = c.defaultLinkProvider(context.Background(), server.URL)
+	require.NotNil(t, preview)
+	require.Equal(t, "Title: Self-signed", renderPreview(preview))
+
+	// Trusted certificates work as normal.
+	c.insecureHosts = nil
+	c.scrapeHTTP = server.Client()
+	preview = c.defaultLinkProvider(context.Background(), server.URL)
+	require.NotNil(t, preview)
+	require.Equal(t, "Title: Self-signed", renderPreview(preview))
+}
@@ -4,58 +4,335 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	seabird "github.com/seabird-chat/seabird-go"
 	"github.com/seabird-chat/seabird-go/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
 type Client struct {
 	*seabird.Client
 
-	callbacks        map[string][]URLCallback
-	messageCallbacks []MessageCallback
+	callbacks        map[string][]registeredCallback
+	messageCallbacks []registeredMessageCallback
 	ignoredBackends  map[string]bool
+	quietOnError     bool
+	schemelessLinks  bool
+	stats            lookupStatsTracker
+
+	// http is shared with all providers, while scrapeHTTP is only used when
+	// fetching arbitrary user-provided URLs. insecureScrapeHTTP is the same,
+	// but skips certificate verification, for insecureHosts and for retrying
+	// sites with invalid certificates.
+	http               *http.Client
+	scrapeHTTP         *http.Client
+	insecureScrapeHTTP *http.Client
+	insecureHosts      []hostPattern
+
+	store       *Store
+	cache       PreviewCache
+	cacheConfig CacheConfig
+	reposts     *repostTracker
+	history     *historyTracker
+	templates   map[string]*template.Template
+
+	// reconnect is the delay between attempts to reconnect to seabird-core,
+	// and inFlight tracks running lookups so Run can wait for them.
+	reconnect backoff
+	inFlight  sync.WaitGroup
+
+	// pool runs all lookups and message callbacks.
+	pool              *workerPool
+	maxURLsPerMessage int
+
+	replies ReplyConfig
+
+	policies *channelPolicies
+
+	// ignoreRules are compiled from the global ignore list.
+	ignoreRules []ignoreRule
+
+	// users holds the ignored and opted out users.
+	users *userFilter
+
+	// admins are the user IDs which can change channel policies with the url
+	// command.
+	admins map[string]bool
+
+	// limiter is nil if there are no rate limits.
+	limiter *rateLimiter
+
+	// lookupCtx is the parent of every provider call's context. It is
+	// cancelled when the Client shuts down, and timeouts holds how long each
+	// provider gets.
+	lookupCtx     context.Context
+	cancelLookups context.CancelFunc
+	timeouts      lookupTimeouts
 }
 
-func NewClient(seabirdCoreUrl, seabirdCoreToken string, rawIgnoredBackends []string) (*Client, error) {
-	client, err := seabird.NewClient(seabirdCoreUrl, seabirdCoreToken)
+func NewClient(config Config) (*Client, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	templates, err := compileTemplates(config.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := internal.NewHTTPClient(config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	scrapeHTTPClient, err := internal.NewHTTPClient(config.scrapeHTTPConfig(false))
 	if err != nil {
 		return nil, err
 	}
 
+	insecureScrapeHTTPClient, err := internal.NewHTTPClient(config.scrapeHTTPConfig(true))
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the connection to a proxy can be checked, so anything it's willing
+	// to forward to is reachable no matter what the guard says.
+	if scrapeConfig := config.scrapeHTTPConfig(false); scrapeConfig.BlockPrivate && scrapeConfig.Transport == nil {
+		if proxies := internal.ProxyHosts(scrapeConfig.Proxy); len(proxies) > 0 {
+			log.Printf("WARNING: internal addresses can't be blocked for requests through proxy %s; configure the proxy to refuse them", strings.Join(proxies, ", "))
+		}
+	}
+
+	insecureHosts, err := compileHostPatterns(config.InsecureHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	var store *Store
+	if config.StorePath != "" {
+		store, err = OpenStore(config.StorePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open store: %w", err)
+		}
+	}
+
+	cacheConfig, cache, err := newCache(config.Cache, store)
+	if err != nil {
+		closeStore(store)
+		return nil, err
+	}
+
+	var reposts *repostTracker
+	if config.Reposts.anyEnabled() {
+		reposts = newRepostTracker(config.Reposts, store)
+	}
+
+	var history *historyTracker
+	if config.History.Enabled {
+		history = newHistoryTracker(config.History, store)
+	}
+
+	client, err := seabird.NewClient(config.CoreURL, config.CoreToken)
+	if err != nil {
+		closeStore(store)
+		return nil, err
+	}
+
+	workers := config.Workers.withDefaults()
+
 	ignoredBackends := make(map[string]bool)
-	for _, backend := range rawIgnoredBackends {
+	for _, backend := range config.IgnoredBackends {
 		ignoredBackends[backend] = true
 	}
 
+	policies, err := newChannelPolicies(config.Policies, store)
+	if err != nil {
+		closeStore(store)
+		return nil, fmt.Errorf("failed to load channel policies: %w", err)
+	}
+
+	ignoreRules, err := compileIgnoreRules(config.IgnoredURLs)
+	if err != nil {
+		closeStore(store)
+		return nil, err
+	}
+
+	users, err := newUserFilter(config.IgnoredUsers, store)
+	if err != nil {
+		closeStore(store)
+		return nil, fmt.Errorf("failed to load user opt-outs: %w", err)
+	}
+
+	var limiter *rateLimiter
+	if config.RateLimits.enabled() {
+		limiter = newRateLimiter(config.RateLimits)
+	}
+
+	admins := make(map[string]bool)
+	for _, admin := range config.Admins {
+		admins[admin] = true
+	}
+
+	lookupCtx, cancelLookups := context.WithCancel(context.Background())
+
 	return &Client{
 		Client:          client,
-		callbacks:       make(map[string][]URLCallback),
+		callbacks:       make(map[string][]registeredCallback),
 		ignoredBackends: ignoredBackends,
+		quietOnError:    config.QuietOnError,
+		schemelessLinks: config.SchemelessLinks,
+		http:            httpClient,
+		scrapeHTTP:      scrapeHTTPClient,
+		store:           store,
+		cache:           cache,
+		cacheConfig:     cacheConfig,
+		reposts:         reposts,
+		history:         history,
+		templates:       templates,
+		reconnect: backoff{
+			min: defaultReconnectMinDelay,
+			max: defaultReconnectMaxDelay,
+		},
+		pool:               newWorkerPool(workers),
+		maxURLsPerMessage:  workers.MaxURLsPerMessage,
+		replies:            config.Replies.withDefaults(),
+		limiter:            limiter,
+		admins:             admins,
+		users:              users,
+		ignoreRules:        ignoreRules,
+		policies:           policies,
+		lookupCtx:          lookupCtx,
+		cancelLookups:      cancelLookups,
+		timeouts:           newLookupTimeouts(config.LookupTimeout, config.ProviderTimeouts),
+		insecureScrapeHTTP: insecureScrapeHTTPClient,
+		insecureHosts:      insecureHosts,
 	}, nil
 }
 
+// newCache fills in the defaults for the given CacheConfig and builds the
+// matching PreviewCache. The cache is nil if caching is disabled.
+func newCache(config CacheConfig, store *Store) (CacheConfig, PreviewCache, error) {
+	if config.TTL == 0 {
+		config.TTL = DefaultCacheTTL
+	}
+
+	if config.NegativeTTL == 0 {
+		config.NegativeTTL = DefaultCacheNegativeTTL
+	}
+
+	providerTTLs := make(map[string]time.Duration)
+	for name, ttl := range config.ProviderTTLs {
+		providerTTLs[strings.ToLower(name)] = ttl
+	}
+	config.ProviderTTLs = providerTTLs
+
+	switch config.Backend {
+	case "", CacheBackendMemory:
+		return config, NewMemoryCache(config.MaxEntries), nil
+	case CacheBackendStore:
+		if store == nil {
+			return config, nil, errors.New("the store cache backend requires a store path")
+		}
+		return config, NewStoreCache(store), nil
+	case CacheBackendNone:
+		return config, nil, nil
+	default:
+		return config, nil, fmt.Errorf("unknown cache backend %q", config.Backend)
+	}
+}
+
+func closeStore(store *Store) {
+	if store != nil {
+		store.Close()
+	}
+}
+
+// Close releases everything held by the Client, including the worker pool,
+// the connection to seabird-core and the store.
+func (c *Client) Close() error {
+	// Anything still queued should give up quickly rather than hold up
+	// shutdown.
+	if c.cancelLookups != nil {
+		c.cancelLookups()
+	}
+
+	if c.pool != nil {
+		c.pool.stop()
+	}
+
+	err := c.Client.Close()
+
+	if c.store != nil {
+		if storeErr := c.store.Close(); err == nil {
+			err = storeErr
+		}
+	}
+
+	return err
+}
+
+// HTTPClient returns the shared HTTP client which providers should use for
+// all outbound requests.
+func (c *Client) HTTPClient() *http.Client {
+	return c.http
+}
+
+// registeredCallback is a PreviewCallback along with the name of the provider
+// it came from.
+type registeredCallback struct {
+	provider string
+	callback PreviewCallback
+}
+
+// registeredMessageCallback is a MessageCallback along with the name of the
+// provider it came from.
+type registeredMessageCallback struct {
+	provider string
+	callback MessageCallback
+}
+
 func (c *Client) Register(p Provider) {
-	for k, v := range p.GetCallbacks() {
-		c.callbacks[k] = append(c.callbacks[k], v)
+	for k, v := range p.GetPreviewCallbacks() {
+		c.callbacks[k] = append(c.callbacks[k], registeredCallback{
+			provider: p.Name(),
+			callback: v,
+		})
 	}
 
 	if cb := p.GetMessageCallback(); cb != nil {
-		c.messageCallbacks = append(c.messageCallbacks, cb)
+		c.messageCallbacks = append(c.messageCallbacks, registeredMessageCallback{
+			provider: p.Name(),
+			callback: cb,
+		})
 	}
 }
 
 // TODO: currently Reply in seabird-go doesn't expose tags, so we copy all the
 // Reply variants here and make sure to set the proper tags.
 func (c *Client) Reply(source *pb.ChannelSource, msg string) error {
+	return c.ReplyBlock(source, msg, nil)
+}
+
+// ReplyBlock sends a message with the given root block. The text is used by
+// backends which don't support blocks.
+func (c *Client) ReplyBlock(source *pb.ChannelSource, text string, root *pb.Block) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	_, err := c.Inner.SendMessage(ctx, &pb.SendMessageRequest{
 		ChannelId: source.GetChannelId(),
-		Text:      msg,
+		Text:      text,
+		RootBlock: root,
 		Tags: map[string]string{
 			"proxy/skip":         "1",
 			"proxy/internal-tag": "1",
@@ -77,85 +354,262 @@ func (c *Client) MentionReplyf(source *pb.ChannelSource, format string, args ...
 	return c.MentionReply(source, fmt.Sprintf(format, args...))
 }
 
+// ReplyPreview renders a Preview and sends it to the given source. Previews
+// which failed or were already sent are skipped.
+func (c *Client) ReplyPreview(source *pb.ChannelSource, p *Preview) error {
+	if p == nil || p.sent || p.Err != nil {
+		return nil
+	}
+
+	if p.spoiler {
+		return c.replySpoiler(source, c.renderPreview(p))
+	}
+
+	return c.Reply(source, c.renderPreview(p))
+}
+
+// replySpoiler sends a message hidden in a spoiler block. Backends without
+// blocks get the plain text, but links can only be in a spoiler if the
+// original message used blocks.
+func (c *Client) replySpoiler(source *pb.ChannelSource, msg string) error {
+	return c.ReplyBlock(source, msg, seabird.NewSpoilerBlock(seabird.NewTextBlock(msg)))
+}
+
+// renderPreview converts a Preview to the text which will be sent to chat,
+// using the configured template for the provider if there is one.
+func (c *Client) renderPreview(p *Preview) string {
+	t, ok := c.templates[strings.ToLower(p.Provider)]
+	if !ok {
+		return renderPreview(p)
+	}
+
+	ret, err := internal.ExecuteTemplate(t, p)
+	if err != nil {
+		log.Printf("Failed to render %s template: %s", p.Provider, err)
+		return renderPreview(p)
+	}
+
+	return appendAnnotations(ret, p)
+}
+
+// renderPreview converts a Preview to the text which will be sent to chat
+// using the default format.
+func renderPreview(p *Preview) string {
+	// Generic page titles have always been displayed a bit differently from
+	// provider results.
+	if p.Provider == titleProviderName {
+		return appendAnnotations(fmt.Sprintf("Title: %s", p.Title), p)
+	}
+
+	return appendAnnotations(fmt.Sprintf("[%s] %s", p.Provider, p.Title), p)
+}
+
+// appendAnnotations adds the annotations from a Preview to its rendered text.
+func appendAnnotations(ret string, p *Preview) string {
+	for _, annotation := range p.Annotations {
+		ret += " " + annotation
+	}
+
+	return ret
+}
+
 // isBlockEvent checks if an event uses the blocks format
 func isBlockEvent(tags map[string]string) bool {
 	return tags["core/original-format"] == "blocks"
 }
 
-func (c *Client) Run() error {
-	events, err := c.StreamEvents(map[string]*pb.CommandMetadata{
+// Run connects to seabird-core and handles events until ctx is cancelled.
+// Whenever the event stream closes, it reconnects (re-registering commands)
+// with exponential backoff. Once ctx is done, it waits for in-flight lookups
+// to finish before returning.
+func (c *Client) Run(ctx context.Context) error {
+	defer c.waitInFlight()
+
+	for {
+		connectedAt := time.Now()
+
+		err := c.runOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// There's no point in retrying if seabird-core doesn't accept our
+		// token.
+		switch status.Code(err) {
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return err
+		}
+
+		if time.Since(connectedAt) > reconnectResetAfter {
+			c.reconnect.reset()
+		}
+
+		delay := c.reconnect.next()
+		log.Printf("Event stream closed: %s; reconnecting in %s", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// waitInFlight waits for any running lookups and commands to finish, up to
+// shutdownGracePeriod.
+func (c *Client) waitInFlight() {
+	done := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownGracePeriod):
+		log.Printf("Gave up waiting for in-flight lookups after %s", shutdownGracePeriod)
+
+		if c.cancelLookups != nil {
+			c.cancelLookups()
+		}
+	}
+}
+
+// shutdownGracePeriod is how long Run waits for in-flight lookups once its
+// context is done.
+const shutdownGracePeriod = 30 * time.Second
+
+// spawn runs fn in a goroutine which Run waits for before returning.
+func (c *Client) spawn(fn func()) {
+	c.inFlight.Add(1)
+
+	go func() {
+		defer c.inFlight.Done()
+		fn()
+	}()
+}
+
+// runOnce streams events from seabird-core until the stream closes or ctx is
+// done.
+func (c *Client) runOnce(ctx context.Context) error {
+	commands := map[string]*pb.CommandMetadata{
 		"isitdown": {
 			Name:      "isitdown",
 			ShortHelp: "<website>",
 			FullHelp:  "Checks if given website is down",
 		},
-	})
+		"url": {
+			Name:      "url",
+			ShortHelp: "optout | optin | status | stats | enable <provider> | disable <provider> | ...",
+			FullHelp:  "Opts you in or out of link previews, or shows or changes how links are looked up in this channel",
+		},
+	}
+
+	if c.history != nil {
+		commands["links"] = &pb.CommandMetadata{
+			Name:      "links",
+			ShortHelp: "search <term> | by <user> | last [n]",
+			FullHelp:  "Finds links previously posted in this channel",
+		}
+	}
+
+	events, err := c.StreamEvents(commands)
 	if err != nil {
 		return err
 	}
-	defer events.Close()
 
-	for event := range events.C {
-		// Skip any events we sent
-		if event.Tags["proxy/internal-tag"] == "1" {
-			continue
-		}
+	// Note that Close waits for the stream to return an error, so it can
+	// only be called once.
+	for {
+		var event *pb.Event
+		var ok bool
 
-		// Skip any events others asked to be skipped
-		if event.Tags["url/skip"] == "1" {
-			continue
+		select {
+		case <-ctx.Done():
+			events.Close()
+			return ctx.Err()
+		case event, ok = <-events.C:
 		}
 
-		switch v := event.GetInner().(type) {
-		case *pb.Event_Command:
-			if v.Command.Command == "isitdown" {
-				c.isItDownCallback(v.Command)
-			}
-		case *pb.Event_Message:
-			fmt.Printf("%+v\n", v)
-			id, err := url.Parse(v.Message.Source.ChannelId)
-			if err != nil {
-				fmt.Printf("failed to parse channel id %q: %s\n", v.Message.Source.ChannelId, err)
-				continue
+		if !ok {
+			if err := events.Close(); err != nil {
+				return err
 			}
 
-			if c.ignoredBackends[id.Scheme] {
-				fmt.Printf("message refers to ignored backend %s\n", id.Scheme)
-				continue
-			}
+			return errors.New("event stream closed")
+		}
 
-			var blockToPass *pb.Block
-			if isBlockEvent(event.Tags) {
-				blockToPass = v.Message.RootBlock
-			}
+		c.handleEvent(event)
+	}
+}
 
-			c.messageCallback(v.Message.Source, v.Message.Text, blockToPass)
-		case *pb.Event_SendMessage:
-			fmt.Printf("%+v\n", v)
-			id, err := url.Parse(v.SendMessage.ChannelId)
-			if err != nil {
-				fmt.Printf("failed to parse channel id %q: %s\n", v.SendMessage.ChannelId, err)
-				continue
-			}
+func (c *Client) handleEvent(event *pb.Event) {
+	// Skip any events we sent
+	if event.Tags["proxy/internal-tag"] == "1" {
+		return
+	}
 
-			if c.ignoredBackends[id.Scheme] {
-				fmt.Printf("message refers to ignored backend %s\n", id.Scheme)
-				continue
-			}
+	// Skip any events others asked to be skipped
+	if event.Tags["url/skip"] == "1" {
+		return
+	}
 
-			var blockToPass *pb.Block
-			if isBlockEvent(event.Tags) {
-				blockToPass = v.SendMessage.RootBlock
-			}
+	switch v := event.GetInner().(type) {
+	case *pb.Event_Command:
+		switch v.Command.Command {
+		case "isitdown":
+			c.isItDownCallback(v.Command)
+		case "links":
+			c.linksCallback(v.Command)
+		case "url":
+			c.urlCallback(v.Command)
+		}
+	case *pb.Event_Message:
+		fmt.Printf("%+v\n", v)
+		id, err := url.Parse(v.Message.Source.ChannelId)
+		if err != nil {
+			fmt.Printf("failed to parse channel id %q: %s\n", v.Message.Source.ChannelId, err)
+			return
+		}
 
-			// We construct a bogus ChannelSource here to make the interface
-			// simpler. Thankfully, we only use .Reply/.Replyf so we only need
-			// the channelId here.
-			c.messageCallback(&pb.ChannelSource{
-				ChannelId: v.SendMessage.ChannelId,
-			}, v.SendMessage.Text, blockToPass)
+		if c.ignoredBackends[id.Scheme] {
+			fmt.Printf("message refers to ignored backend %s\n", id.Scheme)
+			return
 		}
-	}
 
-	return errors.New("event stream closed")
+		if c.users.ignored(v.Message.Source.GetUser()) {
+			return
+		}
+
+		var blockToPass *pb.Block
+		if isBlockEvent(event.Tags) {
+			blockToPass = v.Message.RootBlock
+		}
+
+		c.messageCallback(v.Message.Source, v.Message.Text, blockToPass)
+	case *pb.Event_SendMessage:
+		fmt.Printf("%+v\n", v)
+		id, err := url.Parse(v.SendMessage.ChannelId)
+		if err != nil {
+			fmt.Printf("failed to parse channel id %q: %s\n", v.SendMessage.ChannelId, err)
+			return
+		}
+
+		if c.ignoredBackends[id.Scheme] {
+			fmt.Printf("message refers to ignored backend %s\n", id.Scheme)
+			return
+		}
+
+		var blockToPass *pb.Block
+		if isBlockEvent(event.Tags) {
+			blockToPass = v.SendMessage.RootBlock
+		}
+
+		// We construct a bogus ChannelSource here to make the interface
+		// simpler. Thankfully, we only use .Reply/.Replyf so we only need
+		// the channelId here.
+		c.messageCallback(&pb.ChannelSource{
+			ChannelId: v.SendMessage.ChannelId,
+		}, v.SendMessage.Text, blockToPass)
+	}
 }
@@ -0,0 +1,82 @@
+package url
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRunReconnects(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, fake := newTestClient(t)
+	p := &testProvider{host: host}
+	c.Register(p)
+	c.reconnect = backoff{min: time.Millisecond, max: time.Millisecond}
+
+	fake.endStreams = true
+	fake.events = []*pb.Event{
+		{
+			Inner: &pb.Event_Message{
+				Message: &pb.MessageEvent{
+					Source: testSource,
+					Text:   "check out " + server.URL + "/handled",
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(fake.Streams()) >= 3
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// Commands are registered again on every connection, and every lookup
+	// which started finished before Run returned.
+	for _, stream := range fake.Streams() {
+		require.Contains(t, stream.Commands, "isitdown")
+	}
+	require.Len(t, fake.Messages(), int(p.calls.Load()))
+	require.GreaterOrEqual(t, len(fake.Messages()), 2)
+}
+
+func TestRunShutdown(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(fake.Streams()) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Len(t, fake.Streams(), 1)
+}
+
+func TestRunUnauthenticated(t *testing.T) {
+	c, fake := newTestClient(t)
+	fake.streamErr = status.Error(codes.Unauthenticated, "invalid token")
+
+	err := c.Run(context.Background())
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
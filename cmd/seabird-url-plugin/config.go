@@ -0,0 +1,668 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	url "github.com/seabird-chat/seabird-url-plugin"
+	"github.com/seabird-chat/seabird-url-plugin/internal"
+)
+
+// config is everything which can be set in the config file. Any settings
+// given as environment variables override the file.
+type config struct {
+	IgnoredBackends []string `toml:"ignored_backends"`
+	QuietOnError    bool     `toml:"quiet_on_error"`
+	SchemelessLinks bool     `toml:"schemeless_links"`
+	StorePath       string   `toml:"store_path"`
+
+	LookupTimeout internal.Duration `toml:"lookup_timeout"`
+
+	// Admins are the user IDs which can change channel settings with the url
+	// command.
+	Admins []string `toml:"admins"`
+
+	IgnoredUsers []string `toml:"ignored_users"`
+	IgnoredURLs  []string `toml:"ignored_urls"`
+
+	Core struct {
+		URL   string `toml:"url"`
+		Token string `toml:"token"`
+	} `toml:"core"`
+
+	HTTP struct {
+		Timeout            internal.Duration `toml:"timeout"`
+		UserAgent          string            `toml:"user_agent"`
+		Proxy              string            `toml:"proxy"`
+		MaxBodySize        int64             `toml:"max_body_size"`
+		InsecureSkipVerify bool              `toml:"insecure_skip_verify"`
+
+		// AllowInternal and InternalAllowlist control which internal
+		// addresses links can be fetched from.
+		AllowInternal     bool     `toml:"allow_internal"`
+		InternalAllowlist []string `toml:"internal_allowlist"`
+
+		// InsecureHosts are fetched without verifying their certificates.
+		InsecureHosts []string `toml:"insecure_hosts"`
+	} `toml:"http"`
+
+	Cache struct {
+		Backend      string                       `toml:"backend"`
+		MaxEntries   int                          `toml:"max_entries"`
+		TTL          internal.Duration            `toml:"ttl"`
+		NegativeTTL  internal.Duration            `toml:"negative_ttl"`
+		ProviderTTLs map[string]internal.Duration `toml:"provider_ttls"`
+	} `toml:"cache"`
+
+	Reposts struct {
+		Enabled   bool              `toml:"enabled"`
+		Retention internal.Duration `toml:"retention"`
+	} `toml:"reposts"`
+
+	History struct {
+		Enabled   bool              `toml:"enabled"`
+		Retention internal.Duration `toml:"retention"`
+	} `toml:"history"`
+
+	Workers struct {
+		Workers             int `toml:"workers"`
+		QueueSize           int `toml:"queue_size"`
+		MaxURLsPerMessage   int `toml:"max_urls_per_message"`
+		MaxPerHost          int `toml:"max_per_host"`
+		MaxQueuedPerChannel int `toml:"max_queued_per_channel"`
+	} `toml:"workers"`
+
+	Replies struct {
+		Mode     string            `toml:"mode"`
+		Deadline internal.Duration `toml:"deadline"`
+	} `toml:"replies"`
+
+	RateLimits struct {
+		Channel        rateLimitConfig   `toml:"channel"`
+		User           rateLimitConfig   `toml:"user"`
+		Host           rateLimitConfig   `toml:"host"`
+		NoticeInterval internal.Duration `toml:"notice_interval"`
+	} `toml:"rate_limits"`
+
+	Providers map[string]*providerConfig `toml:"providers"`
+	Channels  map[string]channelConfig   `toml:"channels"`
+	Templates map[string]string          `toml:"templates"`
+}
+
+// providerConfig contains the settings for a single provider. Not every
+// setting applies to every provider.
+type providerConfig struct {
+	// Enabled defaults to true.
+	Enabled *bool `toml:"enabled"`
+
+	Token        string            `toml:"token"`
+	ClientID     string            `toml:"client_id"`
+	ClientSecret string            `toml:"client_secret"`
+	BaseURL      string            `toml:"base_url"`
+	Timeout      internal.Duration `toml:"timeout"`
+}
+
+// rateLimitConfig allows Limit lookups every Per.
+type rateLimitConfig struct {
+	Limit int               `toml:"limit"`
+	Per   internal.Duration `toml:"per"`
+}
+
+func (c rateLimitConfig) rateLimit() url.RateLimit {
+	return url.RateLimit{Limit: c.Limit, Per: c.Per.Duration}
+}
+
+// channelConfig contains the settings for a single channel, keyed by the
+// full channel ID.
+type channelConfig struct {
+	Reposts *bool `toml:"reposts"`
+
+	// Providers enables or disables providers by name, including "title" for
+	// the page title fallback. With AllowlistOnly, only the providers enabled
+	// here are used.
+	Providers     map[string]bool `toml:"providers"`
+	AllowlistOnly bool            `toml:"allowlist_only"`
+
+	// QuietHours is a range like "22:00-07:00" in Timezone, which defaults to
+	// UTC.
+	QuietHours string `toml:"quiet_hours"`
+	Timezone   string `toml:"timezone"`
+
+	IgnoredURLs []string `toml:"ignored_urls"`
+
+	// quietHours is parsed from QuietHours and Timezone when the config is
+	// loaded.
+	quietHours *url.QuietHours
+}
+
+// policy converts the channel's settings to a url.ChannelPolicy.
+func (c channelConfig) policy() url.ChannelPolicy {
+	return url.ChannelPolicy{
+		Providers:     c.Providers,
+		AllowlistOnly: c.AllowlistOnly,
+		QuietHours:    c.quietHours,
+		IgnoredURLs:   c.IgnoredURLs,
+	}
+}
+
+// hasPolicy returns true if any of the channel's settings apply to its
+// policy.
+func (c channelConfig) hasPolicy() bool {
+	return len(c.Providers) > 0 || c.AllowlistOnly || c.quietHours != nil || len(c.IgnoredURLs) > 0
+}
+
+// parse checks the channel's settings and parses its quiet hours.
+func (c *channelConfig) parse() error {
+	for name := range c.Providers {
+		if name != "title" && !isProviderName(name) {
+			return fmt.Errorf("unknown provider %q", name)
+		}
+	}
+
+	if c.QuietHours == "" {
+		if c.Timezone != "" {
+			return errors.New("timezone is only used with quiet_hours")
+		}
+
+		return nil
+	}
+
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return err
+	}
+
+	c.quietHours, err = url.ParseQuietHours(c.QuietHours, loc)
+
+	return err
+}
+
+// providerNames are all the providers which can be configured.
+var providerNames = []string{
+	"bitbucket",
+	"github",
+	"reddit",
+	"shortener",
+	"spotify",
+	"twitter",
+	"xkcd",
+	"youtube",
+}
+
+// loadConfig reads the config file at path, if one is given, and then
+// applies any overrides from the environment.
+func loadConfig(path string) (*config, error) {
+	ret := &config{}
+
+	if path != "" {
+		md, err := toml.DecodeFile(path, ret)
+		if err != nil {
+			return nil, err
+		}
+
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+
+			return nil, fmt.Errorf("unknown config keys: %s", strings.Join(keys, ", "))
+		}
+	}
+
+	if ret.Providers == nil {
+		ret.Providers = make(map[string]*providerConfig)
+	}
+
+	for name, provider := range ret.Providers {
+		if provider == nil {
+			provider = &providerConfig{}
+			ret.Providers[name] = provider
+		}
+
+		if isTimeoutOnlyName(name) {
+			if *provider != (providerConfig{Timeout: provider.Timeout}) {
+				return nil, fmt.Errorf("only timeout can be set for %s", name)
+			}
+
+			continue
+		}
+
+		if !isProviderName(name) {
+			return nil, fmt.Errorf("unknown provider %q", name)
+		}
+	}
+
+	for _, name := range providerNames {
+		if ret.Providers[name] == nil {
+			ret.Providers[name] = &providerConfig{}
+		}
+	}
+
+	if err := ret.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	for channel, channelConfig := range ret.Channels {
+		if err := channelConfig.parse(); err != nil {
+			return nil, fmt.Errorf("invalid config for channel %s: %w", channel, err)
+		}
+
+		ret.Channels[channel] = channelConfig
+	}
+
+	return ret, nil
+}
+
+// timeoutOnlyNames are lookups which aren't providers, but have their own
+// timeouts, so they can be configured as [providers.<name>] with only a
+// timeout.
+var timeoutOnlyNames = []string{
+	"isitdown",
+	"title",
+}
+
+func isTimeoutOnlyName(name string) bool {
+	for _, timeoutName := range timeoutOnlyNames {
+		if name == timeoutName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isProviderName(name string) bool {
+	for _, providerName := range providerNames {
+		if name == providerName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyEnv overrides settings with any which are set in the environment.
+func (c *config) applyEnv() error {
+	envString("SEABIRD_HOST", &c.Core.URL)
+	envString("SEABIRD_TOKEN", &c.Core.Token)
+	envString("STORE_PATH", &c.StorePath)
+
+	if rawIgnoredBackends := os.Getenv("IGNORED_BACKENDS"); rawIgnoredBackends != "" {
+		c.IgnoredBackends = strings.Split(rawIgnoredBackends, ",")
+	}
+
+	if rawAdmins := os.Getenv("ADMINS"); rawAdmins != "" {
+		c.Admins = strings.Split(rawAdmins, ",")
+	}
+
+	if rawIgnoredUsers := os.Getenv("IGNORED_USERS"); rawIgnoredUsers != "" {
+		c.IgnoredUsers = strings.Split(rawIgnoredUsers, ",")
+	}
+
+	if rawIgnoredURLs := os.Getenv("IGNORED_URLS"); rawIgnoredURLs != "" {
+		c.IgnoredURLs = strings.Split(rawIgnoredURLs, ",")
+	}
+
+	if rawAllowlist := os.Getenv("HTTP_INTERNAL_ALLOWLIST"); rawAllowlist != "" {
+		c.HTTP.InternalAllowlist = strings.Split(rawAllowlist, ",")
+	}
+
+	if rawInsecureHosts := os.Getenv("HTTP_INSECURE_HOSTS"); rawInsecureHosts != "" {
+		c.HTTP.InsecureHosts = strings.Split(rawInsecureHosts, ",")
+	}
+
+	envString("HTTP_USER_AGENT", &c.HTTP.UserAgent)
+	envString("HTTP_PROXY_URL", &c.HTTP.Proxy)
+	envString("CACHE_BACKEND", &c.Cache.Backend)
+	envString("REPLY_MODE", &c.Replies.Mode)
+
+	envString("GITHUB_TOKEN", &c.Providers["github"].Token)
+	envString("YOUTUBE_TOKEN", &c.Providers["youtube"].Token)
+	envString("SPOTIFY_CLIENT_ID", &c.Providers["spotify"].ClientID)
+	envString("SPOTIFY_CLIENT_SECRET", &c.Providers["spotify"].ClientSecret)
+
+	// REPOST_CHANNELS enables repost notices for the given channel IDs.
+	if rawChannels := os.Getenv("REPOST_CHANNELS"); rawChannels != "" {
+		if c.Channels == nil {
+			c.Channels = make(map[string]channelConfig)
+		}
+
+		enabled := true
+		for _, channel := range strings.Split(rawChannels, ",") {
+			channel = strings.TrimSpace(channel)
+
+			channelConfig := c.Channels[channel]
+			channelConfig.Reposts = &enabled
+			c.Channels[channel] = channelConfig
+		}
+	}
+
+	// Provider TTLs are given as a list like "github=10m,youtube=24h".
+	if rawProviderTTLs := os.Getenv("CACHE_PROVIDER_TTLS"); rawProviderTTLs != "" {
+		if c.Cache.ProviderTTLs == nil {
+			c.Cache.ProviderTTLs = make(map[string]internal.Duration)
+		}
+
+		for _, item := range strings.Split(rawProviderTTLs, ",") {
+			name, rawTTL, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("invalid CACHE_PROVIDER_TTLS entry %q", item)
+			}
+
+			var ttl internal.Duration
+			if err := ttl.UnmarshalText([]byte(rawTTL)); err != nil {
+				return fmt.Errorf("invalid CACHE_PROVIDER_TTLS entry %q: %w", item, err)
+			}
+
+			c.Cache.ProviderTTLs[strings.TrimSpace(name)] = ttl
+		}
+	}
+
+	return errors.Join(
+		envBool("QUIET_ON_ERROR", &c.QuietOnError),
+		envBool("SCHEMELESS_LINKS", &c.SchemelessLinks),
+		envDuration("HTTP_TIMEOUT", &c.HTTP.Timeout),
+		envInt64("HTTP_MAX_BODY_SIZE", &c.HTTP.MaxBodySize),
+		envBool("HTTP_INSECURE_SKIP_VERIFY", &c.HTTP.InsecureSkipVerify),
+		envBool("HTTP_ALLOW_INTERNAL", &c.HTTP.AllowInternal),
+		envDuration("CACHE_TTL", &c.Cache.TTL),
+		envDuration("CACHE_NEGATIVE_TTL", &c.Cache.NegativeTTL),
+		envInt("CACHE_MAX_ENTRIES", &c.Cache.MaxEntries),
+		envBool("REPOST_ENABLED", &c.Reposts.Enabled),
+		envDuration("REPOST_RETENTION", &c.Reposts.Retention),
+		envBool("HISTORY_ENABLED", &c.History.Enabled),
+		envDuration("HISTORY_RETENTION", &c.History.Retention),
+		envInt("WORKERS", &c.Workers.Workers),
+		envInt("WORKER_QUEUE_SIZE", &c.Workers.QueueSize),
+		envInt("MAX_URLS_PER_MESSAGE", &c.Workers.MaxURLsPerMessage),
+		envInt("MAX_LOOKUPS_PER_HOST", &c.Workers.MaxPerHost),
+		envInt("MAX_QUEUED_PER_CHANNEL", &c.Workers.MaxQueuedPerChannel),
+		envDuration("REPLY_DEADLINE", &c.Replies.Deadline),
+		envDuration("LOOKUP_TIMEOUT", &c.LookupTimeout),
+		envRateLimit("RATE_LIMIT_CHANNEL", &c.RateLimits.Channel),
+		envRateLimit("RATE_LIMIT_USER", &c.RateLimits.User),
+		envRateLimit("RATE_LIMIT_HOST", &c.RateLimits.Host),
+	)
+}
+
+// validate checks the config for any problems, without connecting to
+// anything.
+func (c *config) validate() error {
+	if c.Core.URL == "" || c.Core.Token == "" {
+		return errors.New("missing core url or token (SEABIRD_HOST or SEABIRD_TOKEN)")
+	}
+
+	return c.clientConfig().Validate()
+}
+
+// providerEnabled returns true if the named provider is enabled in the
+// config.
+func (c *config) providerEnabled(name string) bool {
+	enabled := c.Providers[name].Enabled
+	return enabled == nil || *enabled
+}
+
+const (
+	providerActive   = "active"
+	providerDegraded = "degraded"
+	providerDisabled = "disabled"
+)
+
+// providerStatus describes whether a provider will be registered, and if
+// it's missing anything.
+type providerStatus struct {
+	Name   string
+	Status string
+	Note   string
+}
+
+// providerStatus returns the status of the named provider. Providers missing
+// credentials either fall back to a degraded mode or are disabled rather
+// than stopping the plugin from starting.
+func (c *config) providerStatus(name string) providerStatus {
+	if !c.providerEnabled(name) {
+		return providerStatus{name, providerDisabled, "disabled in config"}
+	}
+
+	p := c.Providers[name]
+
+	switch name {
+	case "github":
+		if p.Token == "" {
+			return providerStatus{name, providerDegraded, "no token (GITHUB_TOKEN), using unauthenticated requests"}
+		}
+	case "youtube":
+		if p.Token == "" {
+			return providerStatus{name, providerDegraded, "no token (YOUTUBE_TOKEN), using oEmbed without durations"}
+		}
+	case "spotify":
+		if p.ClientID == "" || p.ClientSecret == "" {
+			return providerStatus{name, providerDisabled, "missing client id or secret (SPOTIFY_CLIENT_ID or SPOTIFY_CLIENT_SECRET)"}
+		}
+	}
+
+	return providerStatus{name, providerActive, ""}
+}
+
+// providerUsable returns true if the named provider should be registered.
+func (c *config) providerUsable(name string) bool {
+	return c.providerStatus(name).Status != providerDisabled
+}
+
+// writeProviderSummary writes a table of the status of every provider.
+func (c *config) writeProviderSummary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "PROVIDER\tSTATUS\tNOTE")
+	for _, name := range providerNames {
+		status := c.providerStatus(name)
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status.Name, status.Status, status.Note)
+	}
+
+	return tw.Flush()
+}
+
+// clientConfig converts the config to what url.NewClient expects.
+func (c *config) clientConfig() url.Config {
+	ret := url.Config{
+		CoreURL:         c.Core.URL,
+		CoreToken:       c.Core.Token,
+		IgnoredBackends: c.IgnoredBackends,
+		QuietOnError:    c.QuietOnError,
+		SchemelessLinks: c.SchemelessLinks,
+		StorePath:       c.StorePath,
+		HTTP: internal.HTTPConfig{
+			Timeout:            c.HTTP.Timeout.Duration,
+			UserAgent:          c.HTTP.UserAgent,
+			Proxy:              c.HTTP.Proxy,
+			MaxBodySize:        c.HTTP.MaxBodySize,
+			InsecureSkipVerify: c.HTTP.InsecureSkipVerify,
+		},
+		AllowInternal:     c.HTTP.AllowInternal,
+		InternalAllowlist: c.HTTP.InternalAllowlist,
+		InsecureHosts:     c.HTTP.InsecureHosts,
+		Cache: url.CacheConfig{
+			Backend:      c.Cache.Backend,
+			MaxEntries:   c.Cache.MaxEntries,
+			TTL:          c.Cache.TTL.Duration,
+			NegativeTTL:  c.Cache.NegativeTTL.Duration,
+			ProviderTTLs: make(map[string]time.Duration),
+		},
+		Reposts: url.RepostConfig{
+			Enabled:   c.Reposts.Enabled,
+			Channels:  make(map[string]bool),
+			Retention: c.Reposts.Retention.Duration,
+		},
+		History: url.HistoryConfig{
+			Enabled:   c.History.Enabled,
+			Retention: c.History.Retention.Duration,
+		},
+		Templates: c.Templates,
+		Workers: url.WorkerConfig{
+			Workers:             c.Workers.Workers,
+			QueueSize:           c.Workers.QueueSize,
+			MaxURLsPerMessage:   c.Workers.MaxURLsPerMessage,
+			MaxPerHost:          c.Workers.MaxPerHost,
+			MaxQueuedPerChannel: c.Workers.MaxQueuedPerChannel,
+		},
+		Replies: url.ReplyConfig{
+			Mode:     c.Replies.Mode,
+			Deadline: c.Replies.Deadline.Duration,
+		},
+		RateLimits: url.RateLimitConfig{
+			Channel:        c.RateLimits.Channel.rateLimit(),
+			User:           c.RateLimits.User.rateLimit(),
+			Host:           c.RateLimits.Host.rateLimit(),
+			NoticeInterval: c.RateLimits.NoticeInterval.Duration,
+		},
+		Policies:         make(map[string]url.ChannelPolicy),
+		Admins:           c.Admins,
+		IgnoredUsers:     c.IgnoredUsers,
+		IgnoredURLs:      c.IgnoredURLs,
+		LookupTimeout:    c.LookupTimeout.Duration,
+		ProviderTimeouts: make(map[string]time.Duration),
+	}
+
+	for name, ttl := range c.Cache.ProviderTTLs {
+		ret.Cache.ProviderTTLs[name] = ttl.Duration
+	}
+
+	for name, p := range c.Providers {
+		if p.Timeout.Duration != 0 {
+			ret.ProviderTimeouts[name] = p.Timeout.Duration
+		}
+	}
+
+	for channel, channelConfig := range c.Channels {
+		if channelConfig.Reposts != nil {
+			ret.Reposts.Channels[channel] = *channelConfig.Reposts
+		}
+
+		if channelConfig.hasPolicy() {
+			ret.Policies[channel] = channelConfig.policy()
+		}
+	}
+
+	return ret
+}
+
+// summary describes the config for the check-config mode, without including
+// any secrets.
+func (c *config) summary() string {
+	channels := make([]string, 0, len(c.Channels))
+	for channel := range c.Channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	return fmt.Sprintf(
+		"core: %s\nchannel overrides: %s",
+		c.Core.URL,
+		strings.Join(channels, ", "),
+	)
+}
+
+func envString(name string, target *string) {
+	if value := os.Getenv(name); value != "" {
+		*target = value
+	}
+}
+
+func envBool(name string, target *bool) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	*target = parsed
+
+	return nil
+}
+
+func envInt(name string, target *int) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	*target = parsed
+
+	return nil
+}
+
+func envInt64(name string, target *int64) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	*target = parsed
+
+	return nil
+}
+
+func envDuration(name string, target *internal.Duration) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	if err := target.UnmarshalText([]byte(value)); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// envRateLimit parses a rate limit given like "10/1m".
+func envRateLimit(name string, target *rateLimitConfig) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	rawLimit, rawPer, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("invalid %s: expected a limit like 10/1m", name)
+	}
+
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	var per internal.Duration
+	if err := per.UnmarshalText([]byte(rawPer)); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	target.Limit = limit
+	target.Per = per
+
+	return nil
+}
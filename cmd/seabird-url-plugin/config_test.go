@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	url "github.com/seabird-chat/seabird-url-plugin"
+)
+
+// clearEnv makes sure no settings leak in from the environment running the
+// tests.
+func clearEnv(t *testing.T) {
+	for _, name := range []string{
+		"SEABIRD_HOST", "SEABIRD_TOKEN", "ADMINS", "IGNORED_USERS", "IGNORED_URLS", "STORE_PATH", "IGNORED_BACKENDS",
+		"QUIET_ON_ERROR", "SCHEMELESS_LINKS", "HTTP_USER_AGENT", "HTTP_PROXY_URL", "HTTP_TIMEOUT",
+		"HTTP_MAX_BODY_SIZE", "HTTP_INSECURE_SKIP_VERIFY", "HTTP_ALLOW_INTERNAL",
+		"HTTP_INTERNAL_ALLOWLIST", "HTTP_INSECURE_HOSTS", "CACHE_BACKEND",
+		"CACHE_TTL", "CACHE_NEGATIVE_TTL", "CACHE_MAX_ENTRIES",
+		"CACHE_PROVIDER_TTLS", "REPOST_ENABLED", "REPOST_CHANNELS",
+		"REPOST_RETENTION", "HISTORY_ENABLED", "HISTORY_RETENTION",
+		"GITHUB_TOKEN", "YOUTUBE_TOKEN", "SPOTIFY_CLIENT_ID",
+		"SPOTIFY_CLIENT_SECRET", "WORKERS", "WORKER_QUEUE_SIZE",
+		"MAX_URLS_PER_MESSAGE", "MAX_LOOKUPS_PER_HOST", "MAX_QUEUED_PER_CHANNEL",
+		"REPLY_MODE", "REPLY_DEADLINE", "LOOKUP_TIMEOUT",
+		"RATE_LIMIT_CHANNEL", "RATE_LIMIT_USER", "RATE_LIMIT_HOST",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	return path
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	clearEnv(t)
+
+	config, err := loadConfig("../../config.example.toml")
+	require.NoError(t, err)
+	require.NoError(t, config.validate())
+
+	clientConfig := config.clientConfig()
+	require.Equal(t, 5*time.Second, clientConfig.HTTP.Timeout)
+	require.False(t, clientConfig.AllowInternal)
+	require.Equal(t, []string{"wiki.internal.example.com", "10.1.2.0/24"}, clientConfig.InternalAllowlist)
+	require.Equal(t, []string{"*.lab.example.com"}, clientConfig.InsecureHosts)
+	require.Equal(t, 10*time.Minute, clientConfig.Cache.ProviderTTLs["github"])
+	require.Equal(t, map[string]bool{"irc://example/#general": true}, clientConfig.Reposts.Channels)
+	require.Equal(t, 10*time.Second, clientConfig.LookupTimeout)
+	require.Equal(t, url.RateLimit{Limit: 5, Per: time.Minute}, clientConfig.RateLimits.User)
+	require.Equal(t, map[string]time.Duration{"github": 3 * time.Second, "shortener": 5 * time.Second}, clientConfig.ProviderTimeouts)
+	policy := clientConfig.Policies["irc://example/#quiet"]
+	require.True(t, policy.AllowlistOnly)
+	require.Equal(t, map[string]bool{"github": true, "xkcd": true}, policy.Providers)
+	require.Equal(t, "22:00-07:00", policy.QuietHours.String())
+	require.Equal(t, "America/Los_Angeles", policy.QuietHours.Location.String())
+	require.Equal(t, []string{"example.com/wiki/.*"}, policy.IgnoredURLs)
+	require.Equal(t, []string{"ci.example.com", "*.internal.example.com"}, clientConfig.IgnoredURLs)
+	require.NotContains(t, clientConfig.Policies, "irc://example/#general")
+	require.Equal(t, []string{"irc://example/admin"}, clientConfig.Admins)
+	require.Equal(t, []string{"otherbot"}, clientConfig.IgnoredUsers)
+	require.False(t, config.providerEnabled("twitter"))
+	require.True(t, config.providerEnabled("reddit"))
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	clearEnv(t)
+
+	path := writeConfig(t, `
+[core]
+url = "https://file.example.com"
+token = "file-token"
+
+[http]
+timeout = "5s"
+
+[providers.github]
+enabled = false
+`)
+
+	t.Setenv("SEABIRD_HOST", "https://env.example.com")
+	t.Setenv("HTTP_TIMEOUT", "1s")
+	t.Setenv("CACHE_PROVIDER_TTLS", "reddit=1m")
+	t.Setenv("RATE_LIMIT_CHANNEL", "3/30s")
+
+	config, err := loadConfig(path)
+	require.NoError(t, err)
+
+	require.Equal(t, "https://env.example.com", config.Core.URL)
+	require.Equal(t, "file-token", config.Core.Token)
+	require.Equal(t, time.Second, config.HTTP.Timeout.Duration)
+	require.Equal(t, time.Minute, config.clientConfig().Cache.ProviderTTLs["reddit"])
+	require.Equal(t, url.RateLimit{Limit: 3, Per: 30 * time.Second}, config.clientConfig().RateLimits.Channel)
+
+	// Missing credentials don't stop the plugin from starting.
+	require.NoError(t, config.validate())
+
+	t.Setenv("HTTP_TIMEOUT", "soon")
+	_, err = loadConfig(path)
+	require.Error(t, err)
+
+	t.Setenv("HTTP_TIMEOUT", "")
+	t.Setenv("RATE_LIMIT_CHANNEL", "3")
+	_, err = loadConfig(path)
+	require.ErrorContains(t, err, "RATE_LIMIT_CHANNEL")
+}
+
+func TestConfigLookupTimeouts(t *testing.T) {
+	clearEnv(t)
+
+	config, err := loadConfig(writeConfig(t, `
+[core]
+url = "https://example.com"
+token = "token"
+
+[providers.title]
+timeout = "2s"
+
+[providers.isitdown]
+timeout = "20s"
+`))
+	require.NoError(t, err)
+	require.NoError(t, config.validate())
+	require.Equal(t, map[string]time.Duration{"title": 2 * time.Second, "isitdown": 20 * time.Second}, config.clientConfig().ProviderTimeouts)
+}
+
+func TestConfigErrors(t *testing.T) {
+	clearEnv(t)
+
+	var tests = []struct {
+		name string
+		data string
+	}{
+		{"unknown key", "unknown = true"},
+		{"unknown provider", "[providers.myspace]\nenabled = true"},
+		{"bad duration", "[http]\ntimeout = \"forever\""},
+		{"title settings", "[providers.title]\nenabled = false"},
+		{"unknown channel provider", "[channels.\"irc://test/#a\"]\nproviders = { myspace = true }"},
+		{"bad quiet hours", "[channels.\"irc://test/#a\"]\nquiet_hours = \"late\""},
+		{"bad timezone", "[channels.\"irc://test/#a\"]\nquiet_hours = \"22:00-07:00\"\ntimezone = \"Mars/Olympus\""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, test.data))
+			require.Error(t, err)
+		})
+	}
+
+	config, err := loadConfig(writeConfig(t, `
+[core]
+url = "https://example.com"
+token = "token"
+
+[reposts]
+enabled = true
+`))
+	require.NoError(t, err)
+	require.EqualError(t, config.validate(), "repost detection requires a store path")
+
+	config.Templates = map[string]string{"github": "{{ .Title"}
+	config.Reposts.Enabled = false
+	require.ErrorContains(t, config.validate(), "invalid template for github")
+
+	config.Templates = nil
+	config.IgnoredURLs = []string{"example.com/("}
+	require.ErrorContains(t, config.validate(), "invalid ignore pattern")
+
+	config.IgnoredURLs = nil
+	config.Channels = map[string]channelConfig{"irc://test/#a": {IgnoredURLs: []string{"ex*ample.com"}}}
+	require.ErrorContains(t, config.validate(), "invalid policy for irc://test/#a")
+
+	// Changes made by admins would be lost on restart without a store.
+	config.Channels = nil
+	config.Admins = []string{"irc://example/admin"}
+	require.EqualError(t, config.validate(), "admins require a store path")
+
+	config.StorePath = filepath.Join(t.TempDir(), "test.db")
+	require.NoError(t, config.validate())
+}
+
+func TestProviderSummary(t *testing.T) {
+	clearEnv(t)
+
+	path := writeConfig(t, `
+[providers.twitter]
+enabled = false
+`)
+
+	t.Setenv("SPOTIFY_CLIENT_ID", "id")
+
+	config, err := loadConfig(path)
+	require.NoError(t, err)
+
+	require.True(t, config.providerUsable("github"))
+	require.False(t, config.providerUsable("spotify"))
+	require.False(t, config.providerUsable("twitter"))
+
+	var buf bytes.Buffer
+	require.NoError(t, config.writeProviderSummary(&buf))
+	require.Equal(t, `PROVIDER   STATUS    NOTE
+bitbucket  active    
+github     degraded  no token (GITHUB_TOKEN), using unauthenticated requests
+reddit     active    
+shortener  active    
+spotify    disabled  missing client id or secret (SPOTIFY_CLIENT_ID or SPOTIFY_CLIENT_SECRET)
+twitter    disabled  disabled in config
+xkcd       active    
+youtube    degraded  no token (YOUTUBE_TOKEN), using oEmbed without durations
+`, buf.String())
+}
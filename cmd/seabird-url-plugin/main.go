@@ -1,80 +1,142 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+
+	// Quiet hours can be given in any time zone, so don't depend on the
+	// system having a zoneinfo database.
+	_ "time/tzdata"
 
 	url "github.com/seabird-chat/seabird-url-plugin"
 )
 
 func main() {
-	coreURL := os.Getenv("SEABIRD_HOST")
-	coreToken := os.Getenv("SEABIRD_TOKEN")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a TOML config file")
+	checkConfig := flag.Bool("check-config", false, "validate the config and exit")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err)
+	}
 
-	if coreURL == "" || coreToken == "" {
-		log.Fatal("Missing SEABIRD_HOST or SEABIRD_TOKEN")
+	err = config.validate()
+	if err != nil {
+		log.Fatalf("Invalid config: %s", err)
 	}
 
-	var ignoredBackends []string
-	rawIgnoredBackends := os.Getenv("IGNORED_BACKENDS")
-	if rawIgnoredBackends != "" {
-		ignoredBackends = strings.Split(rawIgnoredBackends, ",")
+	if *checkConfig {
+		fmt.Println(config.summary())
+		config.writeProviderSummary(os.Stdout)
+		fmt.Println("Config OK")
+		return
 	}
 
-	c, err := url.NewClient(
-		coreURL,
-		coreToken,
-		ignoredBackends,
-	)
+	for _, name := range providerNames {
+		if status := config.providerStatus(name); status.Status == providerDisabled && config.providerEnabled(name) {
+			log.Printf("WARNING: %s provider disabled: %s", name, status.Note)
+		}
+	}
+
+	config.writeProviderSummary(os.Stdout)
+
+	c, err := url.NewClient(config.clientConfig())
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	registerProviders(c)
+	registerProviders(c, config)
+
+	// Shut down cleanly, letting any in-flight lookups finish, when asked
+	// to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	err = c.Run()
+	err = c.Run(ctx)
+	c.Close()
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func registerProviders(c *url.Client) {
+func registerProviders(c *url.Client, config *config) {
 	var err error
-	var provider url.Provider = url.NewBitbucketProvider()
-	c.Register(provider)
+	var provider url.Provider
+
+	if p := config.Providers["bitbucket"]; config.providerUsable("bitbucket") {
+		provider = url.NewBitbucketProvider(url.BitbucketOptions{
+			HTTPClient: c.HTTPClient(),
+			BaseURL:    p.BaseURL,
+		})
+		c.Register(provider)
+	}
 
-	if githubToken := os.Getenv("GITHUB_TOKEN"); githubToken != "" {
-		provider = url.NewGithubProvider(githubToken)
+	if p := config.Providers["github"]; config.providerUsable("github") {
+		provider, err = url.NewGithubProvider(url.GithubOptions{
+			Token:      p.Token,
+			HTTPClient: c.HTTPClient(),
+			BaseURL:    p.BaseURL,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create GitHub provider: %s", err)
+		}
 		c.Register(provider)
-	} else {
-		log.Fatal("Missing GITHUB_TOKEN")
 	}
 
-	provider = url.NewRedditProvider()
-	c.Register(provider)
+	if p := config.Providers["reddit"]; config.providerUsable("reddit") {
+		provider = url.NewRedditProvider(url.RedditOptions{
+			HTTPClient: c.HTTPClient(),
+			BaseURL:    p.BaseURL,
+		})
+		c.Register(provider)
+	}
 
-	spotifyClientID := os.Getenv("SPOTIFY_CLIENT_ID")
-	spotifyClientSecret := os.Getenv("SPOTIFY_CLIENT_SECRET")
-	if spotifyClientID == "" || spotifyClientSecret == "" {
-		log.Fatal("Missing SPOTIFY_CLIENT_ID or SPOTIFY_CLIENT_SECRET")
+	if config.providerUsable("shortener") {
+		provider = url.NewShortenerProvider(url.ShortenerOptions{})
+		c.Register(provider)
 	}
-	provider, err = url.NewSpotifyProvider(spotifyClientID, spotifyClientSecret)
-	if err != nil {
-		log.Fatalf("Failed to connect to Spotify: %s", err)
+
+	if p := config.Providers["spotify"]; config.providerUsable("spotify") {
+		provider, err = url.NewSpotifyProvider(url.SpotifyOptions{
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			HTTPClient:   c.HTTPClient(),
+			BaseURL:      p.BaseURL,
+		})
+		if err != nil {
+			log.Fatalf("Failed to connect to Spotify: %s", err)
+		}
+		c.Register(provider)
 	}
-	c.Register(provider)
 
-	provider = url.NewTwitterProvider()
-	c.Register(provider)
+	if p := config.Providers["twitter"]; config.providerUsable("twitter") {
+		provider = url.NewTwitterProvider(url.TwitterOptions{
+			HTTPClient: c.HTTPClient(),
+			BaseURL:    p.BaseURL,
+		})
+		c.Register(provider)
+	}
 
-	provider = url.NewXKCDProvider()
-	c.Register(provider)
+	if p := config.Providers["xkcd"]; config.providerUsable("xkcd") {
+		provider = url.NewXKCDProvider(url.XKCDOptions{
+			HTTPClient: c.HTTPClient(),
+			BaseURL:    p.BaseURL,
+		})
+		c.Register(provider)
+	}
 
-	if youtubeToken := os.Getenv("YOUTUBE_TOKEN"); youtubeToken != "" {
-		provider = url.NewYoutubeProvider(youtubeToken)
+	if p := config.Providers["youtube"]; config.providerUsable("youtube") {
+		provider = url.NewYoutubeProvider(url.YoutubeOptions{
+			Token:      p.Token,
+			HTTPClient: c.HTTPClient(),
+			BaseURL:    p.BaseURL,
+		})
 		c.Register(provider)
-	} else {
-		log.Fatal("Missing YOUTUBE_TOKEN")
 	}
 }
@@ -1,13 +1,20 @@
 package url
 
 import (
+	"errors"
+	"net/http"
 	"net/url"
 
 	"github.com/seabird-chat/seabird-go/pb"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
+// isItDownName is used to look up the timeout for isitdown checks.
+const isItDownName = "isitdown"
+
 func (c *Client) isItDownCallback(event *pb.CommandEvent) {
-	go func() {
+	c.spawn(func() {
 		url, err := url.Parse(event.Arg)
 		if err != nil {
 			c.MentionReply(event.Source, "URL doesn't appear to be valid")
@@ -18,16 +25,36 @@ func (c *Client) isItDownCallback(event *pb.CommandEvent) {
 			url.Scheme = "http"
 		}
 
-		resp, err := client.Head(url.String())
+		ctx, cancel := c.lookupContext(isItDownName)
+		defer cancel()
+
+		var (
+			resp    *http.Response
+			problem string
+		)
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url.String(), nil)
+		if err == nil {
+			resp, problem, err = c.scrape(req)
+		}
 		if err == nil {
 			defer resp.Body.Close()
 		}
 
+		if errors.Is(err, internal.ErrBlockedAddress) {
+			c.MentionReplyf(event.Source, "%s points at an internal address, so I won't check it.", url)
+			return
+		}
+
 		if err != nil || resp.StatusCode != 200 {
 			c.MentionReplyf(event.Source, "It's not just you! %s looks down from here.", url)
 			return
 		}
 
+		if problem != "" {
+			c.MentionReplyf(event.Source, "It's just you! %s looks up from here, but its certificate is invalid (%s).", url, problem)
+			return
+		}
+
 		c.MentionReplyf(event.Source, "It's just you! %s looks up from here!", url)
-	}()
+	})
 }
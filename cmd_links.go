@@ -0,0 +1,110 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
+)
+
+const (
+	// defaultLinksResults is how many links are shown if no count is given.
+	defaultLinksResults = 5
+
+	// maxLinksResults is the most links which will be shown at once.
+	maxLinksResults = 10
+)
+
+const linksUsage = "Usage: links search <term> | links by <user> | links last [n]"
+
+func (c *Client) linksCallback(event *pb.CommandEvent) {
+	c.spawn(func() {
+		lines, err := c.linksCommand(event.Source.GetChannelId(), event.Arg, time.Now())
+		if err != nil {
+			c.MentionReply(event.Source, err.Error())
+			return
+		}
+
+		for _, line := range lines {
+			c.Reply(event.Source, line)
+		}
+	})
+}
+
+// linksCommand runs a links command in the given channel and returns the
+// lines to reply with. Errors are meant to be shown to the user.
+func (c *Client) linksCommand(channelID, arg string, now time.Time) ([]string, error) {
+	if c.history == nil {
+		return nil, errors.New("Link history is disabled")
+	}
+
+	subcommand, rest, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	rest = strings.TrimSpace(rest)
+
+	var (
+		limit = defaultLinksResults
+		match func(*historyEntry) bool
+	)
+
+	switch subcommand {
+	case "search":
+		if rest == "" {
+			return nil, errors.New(linksUsage)
+		}
+		match = func(e *historyEntry) bool { return e.matches(rest) }
+	case "by":
+		if rest == "" {
+			return nil, errors.New(linksUsage)
+		}
+		match = func(e *historyEntry) bool { return e.postedBy(rest) }
+	case "last":
+		if rest != "" {
+			n, err := strconv.Atoi(rest)
+			if err != nil || n < 1 {
+				return nil, errors.New(linksUsage)
+			}
+			limit = min(n, maxLinksResults)
+		}
+		match = func(e *historyEntry) bool { return true }
+	default:
+		return nil, errors.New(linksUsage)
+	}
+
+	entries, err := c.history.find(channelID, limit, match)
+	if err != nil {
+		log.Printf("Failed to search link history: %s", err)
+		return nil, errors.New("Failed to search link history")
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("No links found")
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, formatHistoryEntry(&entry, now))
+	}
+
+	return lines, nil
+}
+
+// formatHistoryEntry renders a single result for the links command.
+func formatHistoryEntry(e *historyEntry, now time.Time) string {
+	ret := e.URL
+
+	if e.Title != "" {
+		ret += " - " + renderPreview(&Preview{Provider: e.Provider, Title: e.Title})
+	}
+
+	if e.UserName != "" {
+		return fmt.Sprintf("%s (posted by %s %s)", ret, e.UserName, internal.TimeAgo(e.Time, now))
+	}
+
+	return fmt.Sprintf("%s (posted %s)", ret, internal.TimeAgo(e.Time, now))
+}
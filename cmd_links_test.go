@@ -0,0 +1,139 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinksCommand(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	_, err := c.linksCommand(testSource.ChannelId, "last", time.Now())
+	require.EqualError(t, err, "Link history is disabled")
+
+	c.history = newHistoryTracker(HistoryConfig{Enabled: true}, newTestStore(t))
+
+	bob := &pb.ChannelSource{
+		ChannelId: testSource.ChannelId,
+		User:      &pb.User{Id: "irc://test/bob", DisplayName: "bob"},
+	}
+	other := &pb.ChannelSource{
+		ChannelId: "irc://test/#other",
+		User:      testSource.User,
+	}
+
+	c.recordHistory(testSource, "https://github.com/seabird-chat/seabird-url-plugin/issues/1", &Preview{
+		Provider: "Github",
+		Title:    "Issue #1: Links are hard",
+	})
+	c.recordHistory(bob, "https://example.com", nil)
+	c.recordHistory(other, "https://example.com/other", nil)
+	c.recordHistory(bob, "https://example.com/failed", &Preview{Provider: "Test", Err: errors.New("lookup failed")})
+
+	now := time.Now().Add(3 * time.Hour)
+
+	lines, err := c.linksCommand(testSource.ChannelId, "last", now)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"https://example.com/failed (posted by bob 3 hours ago)",
+		"https://example.com (posted by bob 3 hours ago)",
+		"https://github.com/seabird-chat/seabird-url-plugin/issues/1 - [Github] Issue #1: Links are hard (posted by alice 3 hours ago)",
+	}, lines)
+
+	lines, err = c.linksCommand(testSource.ChannelId, "last 1", now)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+
+	// Search matches titles as well as links.
+	lines, err = c.linksCommand(testSource.ChannelId, "search links are HARD", now)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+
+	lines, err = c.linksCommand(testSource.ChannelId, "by Bob", now)
+	require.NoError(t, err)
+	require.Len(t, lines, 2)
+
+	lines, err = c.linksCommand("irc://test/#other", "by alice", now)
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://example.com/other (posted by alice 3 hours ago)"}, lines)
+
+	_, err = c.linksCommand(testSource.ChannelId, "search nothing", now)
+	require.EqualError(t, err, "No links found")
+
+	for _, arg := range []string{"", "search", "by", "last zero", "last 0", "unknown"} {
+		_, err = c.linksCommand(testSource.ChannelId, arg, now)
+		require.EqualError(t, err, linksUsage, "arg %q", arg)
+	}
+}
+
+func TestHistoryTrackerFind(t *testing.T) {
+	store := newTestStore(t)
+	posted := time.Now()
+
+	// Links recorded before entries were keyed by channel are moved over.
+	require.NoError(t, store.put(historyBucket, fmt.Sprintf("%020d%06d", posted.Add(-time.Minute).UnixNano(), 1), historyEntry{
+		ChannelID: testSource.ChannelId,
+		Time:      posted.Add(-time.Minute),
+		URL:       "https://example.com/old",
+	}))
+
+	history := newHistoryTracker(HistoryConfig{Enabled: true}, store)
+	history.record(historyEntry{ChannelID: testSource.ChannelId, Time: posted, URL: "https://example.com/new"})
+
+	// Channels which start the same are kept apart.
+	history.record(historyEntry{ChannelID: testSource.ChannelId + "2", Time: posted, URL: "https://example.com/other"})
+
+	// Entries which can't be read are skipped.
+	require.NoError(t, store.put(historyBucket, historyKeyPrefix(testSource.ChannelId)+"bad", "not an entry"))
+
+	entries, err := history.find(testSource.ChannelId, 10, func(*historyEntry) bool { return true })
+	require.NoError(t, err)
+
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+	}
+	require.Equal(t, []string{"https://example.com/new", "https://example.com/old"}, urls)
+}
+
+func TestHistoryRecordsSkippedLinks(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.Register(&testProvider{host: "example.com"})
+	c.history = newHistoryTracker(HistoryConfig{Enabled: true}, newTestStore(t))
+	c.maxURLsPerMessage = 2
+	c.limiter = newRateLimiter(RateLimitConfig{
+		Channel: RateLimit{Limit: 1, Per: time.Hour},
+	})
+
+	// The first link is looked up, the second is rate limited and the third
+	// is over the per-message limit.
+	c.messageCallback(testSource, "https://example.com/handled https://example.com/limited https://example.com/capped", nil)
+	c.inFlight.Wait()
+
+	// Nothing is looked up during quiet hours.
+	start := time.Duration(time.Now().UTC().Hour()) * time.Hour
+	require.NoError(t, c.SetChannelPolicy(testSource.GetChannelId(), ChannelPolicy{
+		QuietHours: &QuietHours{Start: start, End: start + time.Hour},
+	}))
+	c.messageCallback(testSource, "https://example.com/quiet", nil)
+	c.inFlight.Wait()
+
+	entries, err := c.history.find(testSource.ChannelId, 10, func(*historyEntry) bool { return true })
+	require.NoError(t, err)
+
+	titles := make(map[string]string)
+	for _, entry := range entries {
+		titles[entry.URL] = entry.Title
+	}
+	require.Equal(t, map[string]string{
+		"https://example.com/handled": "handled",
+		"https://example.com/limited": "",
+		"https://example.com/capped":  "",
+		"https://example.com/quiet":   "",
+	}, titles)
+}
@@ -0,0 +1,271 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+)
+
+const urlUsage = "Usage: url optout | url optin | url status | url stats | url enable <provider> | url disable <provider> | " +
+	"url allowlist on|off | url ignore <domain or pattern> | url unignore <domain or pattern> | " +
+	"url quiet <start>-<end> [timezone] | url quiet off"
+
+func (c *Client) urlCallback(event *pb.CommandEvent) {
+	c.spawn(func() {
+		reply, err := c.urlCommand(event.Source, event.Arg)
+		if err != nil {
+			c.MentionReply(event.Source, err.Error())
+			return
+		}
+
+		c.MentionReply(event.Source, reply)
+	})
+}
+
+// urlCommand runs a url command in the source's channel and returns the text
+// to reply with. Errors are meant to be shown to the user.
+func (c *Client) urlCommand(source *pb.ChannelSource, arg string) (string, error) {
+	channelID := source.GetChannelId()
+
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return "", errors.New(urlUsage)
+	}
+
+	subcommand, args := fields[0], fields[1:]
+
+	// Anyone can opt out or see the current policy and stats, but only
+	// admins can change anything.
+	switch subcommand {
+	case "optout", "optin":
+		if len(args) != 0 {
+			return "", errors.New(urlUsage)
+		}
+
+		return c.setOptOut(source.GetUser(), subcommand == "optout")
+	case "status":
+		return formatPolicy(c.ChannelPolicy(channelID)), nil
+	case "stats":
+		return c.formatStats(), nil
+	}
+
+	if !c.admins[source.GetUser().GetId()] {
+		return "", errors.New("Only admins can change link settings")
+	}
+
+	var update func(*ChannelPolicy)
+
+	switch subcommand {
+	case "enable", "disable":
+		if len(args) != 1 {
+			return "", errors.New(urlUsage)
+		}
+
+		name := strings.ToLower(args[0])
+		if !c.hasProvider(name) {
+			return "", fmt.Errorf("Unknown provider %q", args[0])
+		}
+
+		update = func(p *ChannelPolicy) {
+			p.Providers[name] = subcommand == "enable"
+		}
+	case "allowlist":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return "", errors.New(urlUsage)
+		}
+
+		update = func(p *ChannelPolicy) {
+			p.AllowlistOnly = args[0] == "on"
+		}
+	case "ignore", "ignore-domain":
+		if len(args) != 1 {
+			return "", errors.New(urlUsage)
+		}
+
+		pattern, err := normalizeIgnorePattern(args[0])
+		if err != nil {
+			return "", err
+		}
+
+		update = func(p *ChannelPolicy) {
+			if !slices.Contains(p.IgnoredURLs, pattern) {
+				p.IgnoredURLs = append(p.IgnoredURLs, pattern)
+			}
+		}
+	case "unignore", "unignore-domain":
+		if len(args) != 1 {
+			return "", errors.New(urlUsage)
+		}
+
+		pattern, err := normalizeIgnorePattern(args[0])
+		if err != nil {
+			return "", err
+		}
+
+		if !slices.Contains(c.ChannelPolicy(channelID).IgnoredURLs, pattern) {
+			return "", fmt.Errorf("%s isn't ignored", pattern)
+		}
+
+		update = func(p *ChannelPolicy) {
+			p.IgnoredURLs = slices.DeleteFunc(p.IgnoredURLs, func(entry string) bool {
+				return entry == pattern
+			})
+		}
+	case "quiet":
+		quietHours, err := parseQuietArgs(args)
+		if err != nil {
+			return "", err
+		}
+
+		update = func(p *ChannelPolicy) {
+			p.QuietHours = quietHours
+		}
+	default:
+		return "", errors.New(urlUsage)
+	}
+
+	policy, err := c.policies.update(channelID, update)
+	if err != nil {
+		log.Printf("Failed to update policy for %s: %s", channelID, err)
+		return "", errors.New("Failed to save link settings")
+	}
+
+	log.Printf("%s changed the policy for %s: %s", source.GetUser().GetId(), channelID, arg)
+
+	return formatPolicy(policy), nil
+}
+
+// setOptOut handles "url optout" and "url optin".
+func (c *Client) setOptOut(user *pb.User, optedOut bool) (string, error) {
+	if user.GetId() == "" {
+		return "", errors.New("Opting out needs a user ID")
+	}
+
+	// Without a store, the preference would quietly be forgotten on restart.
+	if !c.users.canSave() {
+		return "", errors.New("Opting out isn't available, since there's nowhere to save your preference")
+	}
+
+	if err := c.users.setOptOut(user.GetId(), optedOut, time.Now()); err != nil {
+		log.Printf("Failed to save opt-out for %s: %s", user.GetId(), err)
+		return "", errors.New("Failed to save your preference")
+	}
+
+	if optedOut {
+		return "Your links won't be previewed any more. Use \"url optin\" to undo this.", nil
+	}
+
+	return "Your links will be previewed again.", nil
+}
+
+// parseQuietArgs parses the arguments to "url quiet". It returns nil if quiet
+// hours are being turned off.
+func parseQuietArgs(args []string) (*QuietHours, error) {
+	switch {
+	case len(args) == 1 && args[0] == "off":
+		return nil, nil
+	case len(args) < 1 || len(args) > 2:
+		return nil, errors.New(urlUsage)
+	}
+
+	loc := time.UTC
+	if len(args) == 2 {
+		var err error
+		loc, err = time.LoadLocation(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("Unknown timezone %q", args[1])
+		}
+	}
+
+	quietHours, err := ParseQuietHours(args[0], loc)
+	if err != nil {
+		return nil, errors.New("Quiet hours must look like 22:00-07:00")
+	}
+
+	return quietHours, nil
+}
+
+// hasProvider returns true if a provider with the given lowercase name is
+// registered, including the page title fallback.
+func (c *Client) hasProvider(name string) bool {
+	if name == strings.ToLower(titleProviderName) {
+		return true
+	}
+
+	for _, callbacks := range c.callbacks {
+		for _, cb := range callbacks {
+			if strings.ToLower(cb.provider) == name {
+				return true
+			}
+		}
+	}
+
+	for _, cb := range c.messageCallbacks {
+		if strings.ToLower(cb.provider) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatStats describes how lookups have gone and, if there's a worker pool,
+// how busy it is.
+func (c *Client) formatStats() string {
+	stats := formatLookupStats(c.LookupStats())
+	if c.pool == nil {
+		return stats
+	}
+
+	return c.PoolStats().String() + "; " + stats
+}
+
+// formatPolicy describes a channel's policy on a single line.
+func formatPolicy(p ChannelPolicy) string {
+	var enabled, disabled []string
+	for name, on := range p.Providers {
+		if on {
+			enabled = append(enabled, name)
+		} else {
+			disabled = append(disabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	sort.Strings(disabled)
+
+	var parts []string
+
+	if p.AllowlistOnly {
+		parts = append(parts, "allowlist only: "+listOrNone(enabled))
+	} else {
+		parts = append(parts, "disabled: "+listOrNone(disabled))
+	}
+
+	parts = append(parts, "ignored: "+listOrNone(p.IgnoredURLs))
+
+	if p.QuietHours != nil {
+		quiet := p.QuietHours.String()
+		if p.QuietHours.Location != nil {
+			quiet += " " + p.QuietHours.Location.String()
+		}
+		parts = append(parts, "quiet hours: "+quiet)
+	} else {
+		parts = append(parts, "quiet hours: none")
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+
+	return strings.Join(items, ", ")
+}
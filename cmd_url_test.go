@@ -0,0 +1,118 @@
+package url
+
+import (
+	"testing"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLCommand(t *testing.T) {
+	store := newTestStore(t)
+
+	c, fake := newTestClient(t)
+	c.Register(&testProvider{host: "example.com"})
+	c.admins = map[string]bool{testSource.GetUser().GetId(): true}
+
+	var err error
+	c.policies, err = newChannelPolicies(nil, store)
+	require.NoError(t, err)
+
+	bob := &pb.ChannelSource{
+		ChannelId: testSource.ChannelId,
+		User:      &pb.User{Id: "irc://test/bob", DisplayName: "bob"},
+	}
+
+	// Anyone can check the status, but only admins can change anything.
+	reply, err := c.urlCommand(bob, "status")
+	require.NoError(t, err)
+	require.Equal(t, "disabled: none; ignored: none; quiet hours: none", reply)
+
+	_, err = c.urlCommand(bob, "disable test")
+	require.EqualError(t, err, "Only admins can change link settings")
+
+	var tests = []struct {
+		arg   string
+		reply string
+		err   string
+	}{
+		{"", "", urlUsage},
+		{"frobnicate", "", urlUsage},
+		{"disable", "", urlUsage},
+		{"disable myspace", "", `Unknown provider "myspace"`},
+		{"disable Test", "disabled: test; ignored: none; quiet hours: none", ""},
+		{"disable title", "disabled: test, title; ignored: none; quiet hours: none", ""},
+		{"enable title", "disabled: test; ignored: none; quiet hours: none", ""},
+		{"ignore-domain WWW.Example.org", "disabled: test; ignored: example.org; quiet hours: none", ""},
+		{"ignore-domain example.org", "disabled: test; ignored: example.org; quiet hours: none", ""},
+		{"unignore-domain example.net", "", "example.net isn't ignored"},
+		{"ignore example.com/(", "", "invalid ignore pattern \"example.com/(\": error parsing regexp: missing closing ): `(`"},
+		{"ignore example.com:8080", "", `invalid ignore pattern "example.com:8080": hosts can't include a scheme or port`},
+		{"ignore ftp://example.net", "", `invalid ignore pattern "ftp://example.net": hosts can't include a scheme or port`},
+		{"ignore https://www.example.net/news", "disabled: test; ignored: example.org, example.net/news; quiet hours: none", ""},
+		{"unignore example.net/news", "disabled: test; ignored: example.org; quiet hours: none", ""},
+		{"quiet 25:00", "", "Quiet hours must look like 22:00-07:00"},
+		{"quiet 22:00-07:00 Mars/Olympus", "", `Unknown timezone "Mars/Olympus"`},
+		{"quiet 22:00-07:00 UTC", "disabled: test; ignored: example.org; quiet hours: 22:00-07:00 UTC", ""},
+		{"allowlist maybe", "", urlUsage},
+		{"allowlist on", "allowlist only: title; ignored: example.org; quiet hours: 22:00-07:00 UTC", ""},
+	}
+
+	for _, test := range tests {
+		reply, err := c.urlCommand(testSource, test.arg)
+		if test.err != "" {
+			require.EqualError(t, err, test.err, test.arg)
+			continue
+		}
+
+		require.NoError(t, err, test.arg)
+		require.Equal(t, test.reply, reply, test.arg)
+	}
+
+	// Changes are kept across restarts and override the config.
+	policies, err := newChannelPolicies(map[string]ChannelPolicy{
+		testSource.ChannelId: {Providers: map[string]bool{"github": false}},
+		"irc://test/#other":  {AllowlistOnly: true},
+	}, store)
+	require.NoError(t, err)
+
+	policy := policies.get(testSource.ChannelId)
+	require.True(t, policy.AllowlistOnly)
+	require.Equal(t, map[string]bool{"test": false, "title": true}, policy.Providers)
+	require.Equal(t, []string{"example.org"}, policy.IgnoredURLs)
+	require.Equal(t, "22:00-07:00", policy.QuietHours.String())
+	require.True(t, policies.get("irc://test/#other").AllowlistOnly)
+
+	// Ignored domains are skipped before anything is looked up.
+	_, err = c.urlCommand(testSource, "quiet off")
+	require.NoError(t, err)
+	_, err = c.urlCommand(testSource, "allowlist off")
+	require.NoError(t, err)
+	_, err = c.urlCommand(testSource, "enable test")
+	require.NoError(t, err)
+
+	c.messageCallback(testSource, "https://www.example.org/handled https://example.com/handled", nil)
+	c.inFlight.Wait()
+	require.Equal(t, []string{"[Test] handled"}, fake.Messages())
+
+	_, err = c.urlCommand(testSource, "unignore-domain example.org")
+	require.NoError(t, err)
+	require.Empty(t, c.ChannelPolicy(testSource.ChannelId).IgnoredURLs)
+}
+
+func TestURLStatsCommand(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.quietOnError = true
+	c.Register(&testProvider{host: "example.com"})
+
+	reply, err := c.urlCommand(testSource, "stats")
+	require.NoError(t, err)
+	require.Equal(t, "lookups: none", reply)
+
+	c.lookupURL(testSource, "https://example.com/handled")
+	c.lookupURL(testSource, "https://example.com/failed")
+
+	reply, err = c.urlCommand(testSource, "stats")
+	require.NoError(t, err)
+	require.Equal(t, "lookups: Test 1 handled, 0 not handled, 1 failed", reply)
+}
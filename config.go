@@ -0,0 +1,219 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
+)
+
+// Config contains all the settings needed to build a Client.
+type Config struct {
+	// CoreURL and CoreToken are used to connect to seabird-core.
+	CoreURL   string
+	CoreToken string
+
+	// IgnoredBackends is a list of channel ID schemes (backends) which should
+	// never have URLs looked up.
+	IgnoredBackends []string
+
+	// SchemelessLinks also looks up links written without http:// or
+	// https://, like github.com/foo/bar, but only for hosts a provider
+	// handles and only if they have a path.
+	SchemelessLinks bool
+
+	// QuietOnError disables falling back to the generic page title when a
+	// provider recognized a URL but failed to look it up.
+	QuietOnError bool
+
+	// HTTP controls all outbound HTTP requests made by the Client and any
+	// providers using its HTTP client.
+	HTTP internal.HTTPConfig
+
+	// AllowInternal lets the page title fallback and isitdown fetch links
+	// which point at loopback, link-local, private or multicast addresses.
+	// By default they're refused, other than InternalAllowlist. Requests
+	// through a proxy can't be checked, so the proxy has to refuse internal
+	// addresses itself.
+	AllowInternal bool
+
+	// InternalAllowlist are hosts which can be fetched even though they're
+	// internal. Entries can be host names, IP addresses or CIDR ranges.
+	InternalAllowlist []string
+
+	// InsecureHosts are hosts whose certificates aren't verified when
+	// fetching page titles or checking isitdown. Entries can be exact hosts
+	// like "example.com" or wildcards like "*.example.com". Other hosts with
+	// invalid certificates are still fetched, but their previews are marked.
+	InsecureHosts []string
+
+	// StorePath is the location of the database used for any state which
+	// should survive restarts. If it is empty, nothing is persisted.
+	StorePath string
+
+	// Cache controls how link previews are cached.
+	Cache CacheConfig
+
+	// Reposts controls noting when a link was already posted in a channel.
+	// It requires StorePath to be set.
+	Reposts RepostConfig
+
+	// History controls recording every link posted so it can be found with
+	// the links command. It requires StorePath to be set.
+	History HistoryConfig
+
+	// Templates overrides how previews from specific providers are rendered.
+	// Keys are provider names, matched case-insensitively, and each template
+	// is executed with the *Preview, so it can use .Title, .URL and
+	// (.Field "name").
+	Templates map[string]string
+
+	// Workers controls how many lookups run at once and how much work a
+	// single message or channel can queue up.
+	Workers WorkerConfig
+
+	// Replies controls how previews for messages with multiple links are
+	// sent.
+	Replies ReplyConfig
+
+	// Policies controls which providers are used in specific channels, keyed
+	// by the full channel ID. They can also be changed at runtime with
+	// SetChannelPolicy.
+	Policies map[string]ChannelPolicy
+
+	// IgnoredURLs are never looked up in any channel. See
+	// ChannelPolicy.IgnoredURLs for the format.
+	IgnoredURLs []string
+
+	// Admins are the user IDs which can change channel policies with the url
+	// command. Changes are saved in the store, so it requires StorePath to be
+	// set.
+	Admins []string
+
+	// IgnoredUsers are user IDs or display names whose links are never looked
+	// up. Display names are matched case-insensitively. Users can also opt
+	// themselves out with the url command.
+	IgnoredUsers []string
+
+	// RateLimits controls how many links are looked up for each channel, user
+	// and upstream host over time.
+	RateLimits RateLimitConfig
+
+	// LookupTimeout is how long each provider gets to look up a URL or handle
+	// a message. It defaults to DefaultLookupTimeout.
+	LookupTimeout time.Duration
+
+	// ProviderTimeouts overrides LookupTimeout for specific providers. Keys
+	// are provider names, matched case-insensitively.
+	ProviderTimeouts map[string]time.Duration
+}
+
+// Validate checks the Config for any problems which would stop a Client from
+// being created, without connecting to anything.
+func (c Config) Validate() error {
+	if _, err := internal.NewHTTPClient(c.HTTP); err != nil {
+		return fmt.Errorf("invalid HTTP config: %w", err)
+	}
+
+	if _, err := internal.NewHTTPClient(c.scrapeHTTPConfig(false)); err != nil {
+		return fmt.Errorf("invalid HTTP config: %w", err)
+	}
+
+	if _, err := compileHostPatterns(c.InsecureHosts); err != nil {
+		return fmt.Errorf("invalid insecure hosts: %w", err)
+	}
+
+	switch c.Cache.Backend {
+	case "", CacheBackendMemory, CacheBackendNone:
+	case CacheBackendStore:
+		if c.StorePath == "" {
+			return errors.New("the store cache backend requires a store path")
+		}
+	default:
+		return fmt.Errorf("unknown cache backend %q", c.Cache.Backend)
+	}
+
+	if c.Reposts.anyEnabled() && c.StorePath == "" {
+		return errors.New("repost detection requires a store path")
+	}
+
+	if c.History.Enabled && c.StorePath == "" {
+		return errors.New("link history requires a store path")
+	}
+
+	// Otherwise admins would be told their changes worked, only for them to
+	// be lost on restart.
+	if len(c.Admins) > 0 && c.StorePath == "" {
+		return errors.New("admins require a store path")
+	}
+
+	if err := c.Workers.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Replies.validate(); err != nil {
+		return err
+	}
+
+	if _, err := compileIgnoreRules(c.IgnoredURLs); err != nil {
+		return err
+	}
+
+	for channelID, policy := range c.Policies {
+		if err := policy.compile(); err != nil {
+			return fmt.Errorf("invalid policy for %s: %w", channelID, err)
+		}
+	}
+
+	if err := c.RateLimits.validate(); err != nil {
+		return err
+	}
+
+	if err := validateLookupTimeouts(c.LookupTimeout, c.ProviderTimeouts); err != nil {
+		return err
+	}
+
+	if _, err := compileTemplates(c.Templates); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scrapeHTTPConfig returns the settings for fetching arbitrary user-provided
+// URLs. Certificates are verified unless insecure is set or the HTTP config
+// disables verification everywhere.
+func (c Config) scrapeHTTPConfig(insecure bool) internal.HTTPConfig {
+	ret := c.HTTP
+
+	if insecure {
+		ret.InsecureSkipVerify = true
+	}
+
+	// Users can paste anything, so they shouldn't be able to make us fetch
+	// things only reachable from where the plugin runs.
+	ret.BlockPrivate = !c.AllowInternal
+	ret.AllowedHosts = c.InternalAllowlist
+
+	return ret
+}
+
+// compileTemplates compiles all preview templates, keyed by lowercase
+// provider name.
+func compileTemplates(raw map[string]string) (map[string]*template.Template, error) {
+	ret := make(map[string]*template.Template)
+
+	for name, data := range raw {
+		t, err := internal.TemplateCompile(name, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for %s: %w", name, err)
+		}
+
+		ret[strings.ToLower(name)] = t
+	}
+
+	return ret, nil
+}
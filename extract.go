@@ -0,0 +1,256 @@
+package url
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// extractURLs finds links in plain text. Links start with http:// or
+// https://, and if bareHosts is given, links to those hosts without a scheme,
+// like github.com/foo/bar, are found too and returned with https:// added.
+//
+// Links end at whitespace or anything which can't be part of one, like the >
+// in <https://example.com>. Trailing punctuation is dropped, as are closing
+// brackets without a matching opening one, so a link at the end of a sentence,
+// in parentheses or in a markdown link like [text](https://example.com)
+// comes out as just the link.
+//
+// Anything in backticks is code, which usually means any links are examples,
+// so they're skipped.
+func extractURLs(text string, bareHosts map[string]bool) []string {
+	var ret []string
+
+	text = stripCodeSpans(text)
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+
+		// Links have to start at the beginning of a word.
+		if i > 0 && !isLinkBoundary(text[:i]) {
+			i += size
+			continue
+		}
+
+		if end := matchSchemeLink(text, i); end > i {
+			ret = append(ret, text[i:end])
+			i = end
+			continue
+		}
+
+		if bareHosts != nil && isHostRune(r) {
+			if end := matchBareLink(text, i, bareHosts); end > i {
+				ret = append(ret, "https://"+text[i:end])
+				i = end
+				continue
+			}
+		}
+
+		i += size
+	}
+
+	return ret
+}
+
+// stripCodeSpans replaces anything in backticks, including fenced code
+// blocks, with a space. Like in markdown, a run of backticks is only closed
+// by another run of the same length, and a run which is never closed is left
+// alone.
+func stripCodeSpans(text string) string {
+	var b strings.Builder
+
+	for {
+		start := strings.IndexByte(text, '`')
+		if start == -1 {
+			b.WriteString(text)
+			return b.String()
+		}
+
+		open := start
+		for open < len(text) && text[open] == '`' {
+			open++
+		}
+		fence := text[start:open]
+
+		end := findBacktickRun(text[open:], len(fence))
+		if end == -1 {
+			b.WriteString(text[:open])
+			text = text[open:]
+			continue
+		}
+
+		b.WriteString(text[:start])
+		b.WriteByte(' ')
+		text = text[open+end+len(fence):]
+	}
+}
+
+// findBacktickRun returns the index of the first run of exactly n backticks
+// in text, or -1 if there isn't one.
+func findBacktickRun(text string, n int) int {
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(text) && text[j] == '`' {
+			j++
+		}
+
+		if j-i == n {
+			return i
+		}
+
+		i = j
+	}
+
+	return -1
+}
+
+// isLinkBoundary returns true if a link can start right after before.
+func isLinkBoundary(before string) bool {
+	r, _ := utf8.DecodeLastRuneInString(before)
+
+	// Anything which could be part of a host or path means we're already in
+	// the middle of something else, like an email address. Other scripts
+	// don't always put spaces between words, so only ASCII counts.
+	if r >= utf8.RuneSelf {
+		return true
+	}
+
+	return !isHostRune(r) && !strings.ContainsRune("/@:%&=?#~+", r)
+}
+
+// isHostRune returns true if r can be part of a host name.
+func isHostRune(r rune) bool {
+	return r == '.' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// matchSchemeLink returns the end of a link starting with http:// or
+// https:// at start, or start if there isn't one.
+func matchSchemeLink(text string, start int) int {
+	rest := text[start:]
+
+	var scheme string
+	for _, prefix := range []string{"https://", "http://"} {
+		if len(rest) >= len(prefix) && strings.EqualFold(rest[:len(prefix)], prefix) {
+			scheme = prefix
+			break
+		}
+	}
+	if scheme == "" {
+		return start
+	}
+
+	end := scanLink(text, start+len(scheme))
+
+	// There needs to be at least some of a host.
+	host, _ := utf8.DecodeRuneInString(text[start+len(scheme) : end])
+	if end == start+len(scheme) || (!isHostRune(host) && host != '[') {
+		return start
+	}
+
+	return end
+}
+
+// matchBareLink returns the end of a link without a scheme at start if its
+// host is in hosts and it has a path, or start if there isn't one. Hosts on
+// their own are left alone, since they're usually just mentioned in passing.
+func matchBareLink(text string, start int, hosts map[string]bool) int {
+	hostEnd := start
+	for hostEnd < len(text) {
+		r, size := utf8.DecodeRuneInString(text[hostEnd:])
+		if !isHostRune(r) {
+			break
+		}
+		hostEnd += size
+	}
+
+	if hostEnd >= len(text) || text[hostEnd] != '/' || !hosts[strings.ToLower(text[start:hostEnd])] {
+		return start
+	}
+
+	end := scanLink(text, hostEnd)
+	if end <= hostEnd+1 {
+		return start
+	}
+
+	return end
+}
+
+// scanLink returns where the link containing text[start] ends.
+func scanLink(text string, start int) int {
+	var (
+		end    = start
+		parens int
+		square int
+	)
+
+scan:
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+
+		switch {
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			break scan
+		case strings.ContainsRune("<>\"`{}|\\^", r):
+			break scan
+		case r == '“' || r == '”' || r == '‘' || r == '’' || r == '«' || r == '»':
+			break scan
+		case r == '(':
+			parens++
+		case r == ')':
+			if parens == 0 {
+				break scan
+			}
+			parens--
+		case r == '[':
+			square++
+		case r == ']':
+			if square == 0 {
+				break scan
+			}
+			square--
+		}
+
+		end += size
+	}
+
+	// Punctuation at the end of a link is almost always part of the
+	// surrounding text.
+	for end > start {
+		r, size := utf8.DecodeLastRuneInString(text[start:end])
+		if !isTrailingPunctuation(r) {
+			break
+		}
+		end -= size
+	}
+
+	return end
+}
+
+// isTrailingPunctuation returns true if r shouldn't end a link.
+func isTrailingPunctuation(r rune) bool {
+	if strings.ContainsRune(".,;:!?'*", r) {
+		return true
+	}
+
+	// This covers things like the full stops and commas used in CJK text.
+	return r >= utf8.RuneSelf && unicode.IsPunct(r)
+}
+
+// extractURLs finds the links in a plain text message, including links
+// without a scheme to hosts which a provider handles, if that's enabled.
+func (c *Client) extractURLs(text string) []string {
+	var bareHosts map[string]bool
+	if c.schemelessLinks {
+		bareHosts = make(map[string]bool)
+		for host := range c.callbacks {
+			bareHosts[strings.ToLower(host)] = true
+		}
+	}
+
+	return extractURLs(text, bareHosts)
+}
@@ -0,0 +1,134 @@
+package url
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractURLs(t *testing.T) {
+	var tests = []struct {
+		text     string
+		expected []string
+	}{
+		{"no links here", nil},
+		{"https://example.com", []string{"https://example.com"}},
+		{"look at https://example.com/a and http://example.org/b", []string{"https://example.com/a", "http://example.org/b"}},
+		{"HTTPS://Example.com/Page", []string{"HTTPS://Example.com/Page"}},
+
+		// Trailing punctuation belongs to the sentence.
+		{"see https://example.com.", []string{"https://example.com"}},
+		{"https://example.com/a, https://example.com/b; ok?", []string{"https://example.com/a", "https://example.com/b"}},
+		{"wow https://example.com/page!!!", []string{"https://example.com/page"}},
+		{"'https://example.com/quoted'", []string{"https://example.com/quoted"}},
+		{"\"https://example.com/quoted\"", []string{"https://example.com/quoted"}},
+		{"**https://example.com/bold**", []string{"https://example.com/bold"}},
+		{"https://example.com/?q=1&page=2#top.", []string{"https://example.com/?q=1&page=2#top"}},
+
+		// Parentheses are kept if they're balanced.
+		{"(see https://example.com/page)", []string{"https://example.com/page"}},
+		{"https://en.wikipedia.org/wiki/Go_(programming_language)", []string{"https://en.wikipedia.org/wiki/Go_(programming_language)"}},
+		{"(https://en.wikipedia.org/wiki/Go_(programming_language))", []string{"https://en.wikipedia.org/wiki/Go_(programming_language)"}},
+		{"https://en.wikipedia.org/wiki/Go_(programming_language).", []string{"https://en.wikipedia.org/wiki/Go_(programming_language)"}},
+
+		// Angle brackets and markdown.
+		{"<https://example.com/page>", []string{"https://example.com/page"}},
+		{"[some text](https://example.com/page)", []string{"https://example.com/page"}},
+		{"[https://example.com/a](https://example.com/b)", []string{"https://example.com/a", "https://example.com/b"}},
+		{"[link](https://example.com/page \"title\")", []string{"https://example.com/page"}},
+
+		// Internationalized hosts and text.
+		{"https://bücher.example/straße", []string{"https://bücher.example/straße"}},
+		{"看这个https://example.com/页面。", []string{"https://example.com/页面"}},
+		{"看这个 https://example.com/页面。", []string{"https://example.com/页面"}},
+		{"“https://example.com/curly”", []string{"https://example.com/curly"}},
+		{"http://[::1]:8080/path", []string{"http://[::1]:8080/path"}},
+
+		// Links in code are examples.
+		{"`https://example.com/code`", nil},
+		{"see `https://example.com/a` and https://example.com/b", []string{"https://example.com/b"}},
+		{"```\ncurl https://example.com/fenced\n```\nhttps://example.com/after", []string{"https://example.com/after"}},
+		{"``a ` https://example.com/nested``", nil},
+		{"an unclosed ` https://example.com/page", []string{"https://example.com/page"}},
+
+		// Things which aren't links.
+		{"https://", nil},
+		{"https:// example.com", nil},
+		{"https://.", nil},
+		{"xhttps://example.com", nil},
+		{"mailto:someone@example.com", nil},
+		{"github.com/foo/bar", nil},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, extractURLs(test.text, nil), test.text)
+	}
+}
+
+func TestExtractURLsSchemeless(t *testing.T) {
+	hosts := map[string]bool{"github.com": true, "youtu.be": true}
+
+	var tests = []struct {
+		text     string
+		expected []string
+	}{
+		{"github.com/foo/bar", []string{"https://github.com/foo/bar"}},
+		{"check GitHub.com/foo/bar.", []string{"https://GitHub.com/foo/bar"}},
+		{"(youtu.be/abc)", []string{"https://youtu.be/abc"}},
+		{"https://github.com/foo/bar", []string{"https://github.com/foo/bar"}},
+
+		// Hosts on their own, unknown hosts and hosts inside other things
+		// are left alone.
+		{"I like github.com", nil},
+		{"github.com/", nil},
+		{"example.com/foo", nil},
+		{"notgithub.com/foo", nil},
+		{"someone@github.com/foo", nil},
+		{"https://example.com/github.com/foo", []string{"https://example.com/github.com/foo"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, extractURLs(test.text, hosts), test.text)
+	}
+}
+
+func TestClientExtractURLs(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.Register(&testProvider{host: "example.com"})
+
+	text := "https://example.org/a example.com/b"
+	require.Equal(t, []string{"https://example.org/a"}, c.extractURLs(text))
+
+	c.schemelessLinks = true
+	require.Equal(t, []string{"https://example.org/a", "https://example.com/b"}, c.extractURLs(text))
+}
+
+func FuzzExtractURLs(f *testing.F) {
+	for _, seed := range []string{
+		"https://example.com",
+		"(see https://en.wikipedia.org/wiki/Go_(programming_language)).",
+		"[text](https://example.com/page) <https://example.org>",
+		"github.com/foo/bar, https://bücher.example/。",
+		"http://[::1]:8080/ https://",
+		"`https://example.com` ``` https://example.org ``` `",
+	} {
+		f.Add(seed)
+	}
+
+	hosts := map[string]bool{"github.com": true}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		for _, raw := range extractURLs(text, hosts) {
+			lower := strings.ToLower(raw)
+			require.True(t, strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://"), raw)
+
+			// Everything found has to have come from the text, other than
+			// the scheme added to links without one.
+			require.True(t, strings.Contains(text, raw) || strings.Contains(text, strings.TrimPrefix(raw, "https://")), raw)
+
+			require.NotContains(t, raw, " ")
+			require.False(t, strings.HasSuffix(raw, "."), raw)
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package url
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	seabird "github.com/seabird-chat/seabird-go"
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeSeabird is a stand-in for seabird-core which records every message
+// sent through it.
+type fakeSeabird struct {
+	pb.SeabirdClient
+
+	lock sync.Mutex
+	sent []*pb.SendMessageRequest
+
+	// events are sent on every event stream. If endStreams is set, each
+	// stream closes once they have been sent, otherwise it stays open until
+	// its context is done. If streamErr is set, opening a stream fails.
+	events     []*pb.Event
+	endStreams bool
+	streamErr  error
+	streams    []*pb.StreamEventsRequest
+
+	// If stall is set, sending a message waits until it's closed, like a
+	// seabird-core which has stopped responding.
+	stall chan struct{}
+}
+
+func (f *fakeSeabird) StreamEvents(ctx context.Context, in *pb.StreamEventsRequest, opts ...grpc.CallOption) (pb.Seabird_StreamEventsClient, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.streams = append(f.streams, in)
+
+	if f.streamErr != nil {
+		return nil, f.streamErr
+	}
+
+	return &fakeEventStream{
+		ctx:    ctx,
+		events: append([]*pb.Event(nil), f.events...),
+		end:    f.endStreams,
+	}, nil
+}
+
+// Streams returns the requests for every event stream opened so far.
+func (f *fakeSeabird) Streams() []*pb.StreamEventsRequest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]*pb.StreamEventsRequest(nil), f.streams...)
+}
+
+// fakeEventStream is a single event stream opened on a fakeSeabird.
+type fakeEventStream struct {
+	grpc.ClientStream
+
+	ctx    context.Context
+	events []*pb.Event
+	end    bool
+}
+
+func (s *fakeEventStream) Recv() (*pb.Event, error) {
+	if len(s.events) > 0 {
+		event := s.events[0]
+		s.events = s.events[1:]
+		return event, nil
+	}
+
+	if s.end {
+		return nil, io.EOF
+	}
+
+	<-s.ctx.Done()
+
+	return nil, s.ctx.Err()
+}
+
+func (f *fakeSeabird) SendMessage(ctx context.Context, in *pb.SendMessageRequest, opts ...grpc.CallOption) (*pb.SendMessageResponse, error) {
+	if f.stall != nil {
+		<-f.stall
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.sent = append(f.sent, in)
+
+	return &pb.SendMessageResponse{}, nil
+}
+
+// Messages returns the text of all messages sent so far.
+func (f *fakeSeabird) Messages() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var ret []string
+	for _, msg := range f.sent {
+		ret = append(ret, msg.Text)
+	}
+
+	return ret
+}
+
+// newTestClient returns a Client which is not connected to a real
+// seabird-core instance, along with the fake which records replies.
+func newTestClient(t *testing.T) (*Client, *fakeSeabird) {
+	t.Helper()
+
+	fake := &fakeSeabird{}
+
+	return &Client{
+		Client:          &seabird.Client{Inner: fake},
+		callbacks:       make(map[string][]registeredCallback),
+		ignoredBackends: make(map[string]bool),
+		http:            http.DefaultClient,
+		scrapeHTTP:      http.DefaultClient,
+		insecureScrapeHTTP: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+			},
+		},
+		timeouts: newLookupTimeouts(0, nil),
+		policies: &channelPolicies{policies: make(map[string]ChannelPolicy)},
+		users:    &userFilter{optOuts: make(map[string]bool)},
+	}, fake
+}
+
+// fixture is a single canned response.
+type fixture struct {
+	status      int
+	contentType string
+	data        []byte
+}
+
+// newFixtureServer starts an httptest.Server which responds to each path in
+// routes with the contents of the matching file in testdata/<provider>. A
+// route may be given as "<status>:<file>" to respond with a different status
+// code, and the file may be left empty. Any other path returns a 404.
+func newFixtureServer(t *testing.T, provider string, routes map[string]string) *httptest.Server {
+	t.Helper()
+
+	fixtures := make(map[string]fixture)
+	for path, name := range routes {
+		f := fixture{status: http.StatusOK}
+
+		if rawStatus, rest, ok := strings.Cut(name, ":"); ok {
+			status, err := strconv.Atoi(rawStatus)
+			require.NoError(t, err)
+
+			f.status = status
+			name = rest
+		}
+
+		if name != "" {
+			data, err := os.ReadFile(filepath.Join("testdata", provider, name))
+			require.NoError(t, err)
+			f.data = data
+		}
+
+		switch filepath.Ext(name) {
+		case ".json":
+			f.contentType = "application/json"
+		case ".html":
+			f.contentType = "text/html; charset=utf-8"
+		}
+
+		fixtures[path] = f
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := fixtures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if f.contentType != "" {
+			w.Header().Set("Content-Type", f.contentType)
+		}
+
+		w.WriteHeader(f.status)
+		w.Write(f.data)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// testSource is the ChannelSource used for all test messages.
+var testSource = &pb.ChannelSource{
+	ChannelId: "irc://test/#channel",
+	User: &pb.User{
+		Id:          "irc://test/alice",
+		DisplayName: "alice",
+	},
+}
+
+// mustParseURL parses a URL the same way messageCallback would.
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+
+	u, err := url.ParseRequestURI(raw)
+	require.NoError(t, err)
+
+	return u
+}
+
+// urlTestCase describes a single URL being run through a provider callback.
+// If reply is empty and failed is false, the URL is expected to not be
+// handled.
+type urlTestCase struct {
+	name   string
+	url    string
+	reply  string
+	fields map[string]string
+	failed bool
+}
+
+// runURLTests runs every test case through the callback registered for the
+// URL's host and checks the preview which was returned.
+func runURLTests(t *testing.T, p Provider, tests []urlTestCase) {
+	t.Helper()
+
+	callbacks := p.GetPreviewCallbacks()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, fake := newTestClient(t)
+
+			u := mustParseURL(t, test.url)
+			cb, ok := callbacks[u.Host]
+			require.True(t, ok, "no callback for host %q", u.Host)
+
+			preview := cb(context.Background(), c, testSource, u)
+
+			// Providers should never reply on their own.
+			require.Empty(t, fake.Messages())
+
+			if test.failed {
+				require.NotNil(t, preview)
+				require.Error(t, preview.Err)
+				require.Equal(t, p.Name(), preview.Provider)
+				return
+			}
+
+			if test.reply == "" {
+				require.Nil(t, preview)
+				return
+			}
+
+			require.NotNil(t, preview)
+			require.NoError(t, preview.Err)
+			require.Equal(t, p.Name(), preview.Provider)
+			require.Equal(t, test.reply, renderPreview(preview))
+
+			for name, value := range test.fields {
+				require.Equal(t, value, preview.Field(name), "field %q", name)
+			}
+		})
+	}
+}
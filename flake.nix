@@ -46,7 +46,7 @@
 
             src = ./.;
 
-            vendorHash = "sha256-H3IGrpCrmFocN2b05FZAkOkmdKOuvIoLOjgtE/wYJKg=";
+            vendorHash = "sha256-VVsIjWQ5R+KrG9zvmoW9mRyWxHIiBWw/aAYuGtS2GSc=";
 
             subPackages = [ "cmd/${pname}" ];
 
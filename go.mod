@@ -3,17 +3,19 @@ module github.com/seabird-chat/seabird-url-plugin
 go 1.24.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/channelmeter/iso8601duration v0.0.0-20150204201828-8da3af7a2a61
 	github.com/dustin/go-humanize v1.0.1
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/seabird-chat/seabird-go v0.6.0
 	github.com/spf13/cast v1.7.1
-	github.com/stretchr/testify v1.7.0
-	github.com/unknwon/com v1.0.1
+	github.com/stretchr/testify v1.10.0
 	github.com/yhat/scrape v0.0.0-20161128144610-24b7890b0945
-	github.com/zmb3/spotify v1.3.0
+	github.com/zmb3/spotify/v2 v2.4.3
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/net v0.38.0
 	golang.org/x/oauth2 v0.29.0
+	google.golang.org/grpc v1.71.1
 )
 
 require (
@@ -23,7 +25,6 @@ require (
 	golang.org/x/sys v0.32.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250404141209-ee84b53bf3d0 // indirect
-	google.golang.org/grpc v1.71.1 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,221 @@
+package url
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+)
+
+// HistoryConfig controls the searchable history of every link posted.
+type HistoryConfig struct {
+	// Enabled turns on recording links and the links command.
+	Enabled bool
+
+	// Retention is how long links are kept in the history. If it is zero,
+	// links are kept forever.
+	Retention time.Duration
+}
+
+// historyBucket is the Store bucket used for link history.
+const historyBucket = "history"
+
+// historyPruneInterval is how many links are recorded between sweeps for
+// expired entries.
+const historyPruneInterval = 100
+
+// historyEntry is a single link posted in a channel.
+type historyEntry struct {
+	ChannelID string
+	UserID    string
+	UserName  string
+	Time      time.Time
+	URL       string
+	Provider  string
+	Title     string
+}
+
+// matches returns true if the term appears in the link or its title.
+func (e *historyEntry) matches(term string) bool {
+	term = strings.ToLower(term)
+
+	return strings.Contains(strings.ToLower(e.URL), term) ||
+		strings.Contains(strings.ToLower(e.Title), term)
+}
+
+// postedBy returns true if the entry was posted by the given user, matched
+// by ID or display name.
+func (e *historyEntry) postedBy(user string) bool {
+	return strings.EqualFold(e.UserName, user) || (e.UserID != "" && e.UserID == user)
+}
+
+// historyTracker records links in the Store.
+type historyTracker struct {
+	config HistoryConfig
+	store  *Store
+
+	lock    sync.Mutex
+	records int
+}
+
+func newHistoryTracker(config HistoryConfig, store *Store) *historyTracker {
+	t := &historyTracker{
+		config: config,
+		store:  store,
+	}
+	t.migrate()
+	t.prune()
+
+	return t
+}
+
+// historyKeyPrefix is the start of the key of every link posted in a
+// channel, so a channel's links can be found without going through every
+// other channel's.
+func historyKeyPrefix(channelID string) string {
+	return channelID + "\x00"
+}
+
+// migrate moves links recorded before keys started with the channel to
+// where find will look for them.
+func (t *historyTracker) migrate() {
+	old := make(map[string]historyEntry)
+
+	err := t.store.forEach(historyBucket, func(key string, data []byte) error {
+		if strings.Contains(key, "\x00") {
+			return nil
+		}
+
+		var entry historyEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Ignoring invalid history entry %s: %s", key, err)
+			return nil
+		}
+
+		old[key] = entry
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to migrate link history: %s", err)
+		return
+	}
+
+	for key, entry := range old {
+		if err := t.store.put(historyBucket, historyKeyPrefix(entry.ChannelID)+key, entry); err != nil {
+			log.Printf("Failed to migrate history entry %s: %s", key, err)
+			continue
+		}
+
+		if err := t.store.delete(historyBucket, key); err != nil {
+			log.Printf("Failed to migrate history entry %s: %s", key, err)
+		}
+	}
+}
+
+// record adds a link to the history.
+func (t *historyTracker) record(entry historyEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.records++
+
+	// Keys are grouped by channel and ordered by time so the most recent
+	// links in a channel can be found first. The counter keeps links posted
+	// at the same instant apart.
+	key := historyKeyPrefix(entry.ChannelID) + fmt.Sprintf("%020d%06d", entry.Time.UnixNano(), t.records%1000000)
+
+	if err := t.store.put(historyBucket, key, entry); err != nil {
+		log.Printf("Failed to record %s in history: %s", entry.URL, err)
+	}
+
+	if t.records%historyPruneInterval == 0 {
+		t.prune()
+	}
+}
+
+// find returns up to limit links posted in the given channel which match,
+// most recent first.
+func (t *historyTracker) find(channelID string, limit int, match func(*historyEntry) bool) ([]historyEntry, error) {
+	var ret []historyEntry
+
+	err := t.store.reverseEach(historyBucket, historyKeyPrefix(channelID), func(key string, data []byte) (bool, error) {
+		// One bad entry shouldn't break searching everything else.
+		var entry historyEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Ignoring invalid history entry %s: %s", key, err)
+			return true, nil
+		}
+
+		if entry.ChannelID == channelID && match(&entry) {
+			ret = append(ret, entry)
+		}
+
+		return len(ret) < limit, nil
+	})
+
+	return ret, err
+}
+
+// prune removes links older than the retention period.
+func (t *historyTracker) prune() {
+	if t.config.Retention <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-t.config.Retention)
+
+	err := t.store.deleteWhere(historyBucket, func(key string, data []byte) bool {
+		var entry historyEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return true
+		}
+
+		return entry.Time.Before(cutoff)
+	})
+	if err != nil {
+		log.Printf("Failed to prune link history: %s", err)
+	}
+}
+
+// recordHistory adds a link which was posted, along with what it resolved
+// to, to the history. Links are recorded in their canonical form, so
+// searches find every way of writing the same link.
+func (c *Client) recordHistory(source *pb.ChannelSource, raw string, preview *Preview) {
+	if c.history == nil {
+		return
+	}
+
+	entry := historyEntry{
+		ChannelID: source.GetChannelId(),
+		UserID:    source.GetUser().GetId(),
+		UserName:  source.GetUser().GetDisplayName(),
+		Time:      time.Now(),
+		URL:       canonicalURL(raw),
+	}
+
+	if preview != nil && preview.Err == nil {
+		entry.Provider = preview.Provider
+		entry.Title = preview.Title
+	}
+
+	c.history.record(entry)
+}
+
+// recordSkipped adds links which were seen but not looked up to the history.
+// Recording them touches the Store, so it's done in the background.
+func (c *Client) recordSkipped(source *pb.ChannelSource, rawurls []string) {
+	if c.history == nil || len(rawurls) == 0 {
+		return
+	}
+
+	c.spawn(func() {
+		for _, raw := range rawurls {
+			c.recordHistory(source, raw, nil)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// hostPattern matches hosts. Patterns look like one of:
+//
+//	example.com             exactly that host (a leading "www." is ignored)
+//	*.example.com           example.com and any of its subdomains
+//	*                       any host
+type hostPattern struct {
+	host     string
+	wildcard bool
+}
+
+func compileHostPattern(raw string) (hostPattern, error) {
+	var ret hostPattern
+
+	host := normalizeDomain(strings.TrimSpace(raw))
+
+	switch {
+	case host == "":
+		return ret, errors.New("missing host")
+	case host == "*":
+		ret.wildcard = true
+	case strings.HasPrefix(host, "*."):
+		ret.wildcard = true
+		ret.host = strings.TrimPrefix(host, "*.")
+	case strings.Contains(host, "*"):
+		return ret, errors.New("wildcards are only allowed at the start of the host")
+	case strings.Contains(host, ":") && net.ParseIP(host) == nil:
+		// Ports are never compared, so a pattern with one, or with a scheme,
+		// could never match anything.
+		return ret, errors.New("hosts can't include a scheme or port")
+	default:
+		ret.host = host
+	}
+
+	return ret, nil
+}
+
+// compileHostPatterns compiles a whole list of host patterns.
+func compileHostPatterns(raw []string) ([]hostPattern, error) {
+	var ret []hostPattern
+	for _, entry := range raw {
+		pattern, err := compileHostPattern(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", entry, err)
+		}
+		ret = append(ret, pattern)
+	}
+
+	return ret, nil
+}
+
+// String returns the pattern in a normalized form, which compiles to the same
+// pattern.
+func (p hostPattern) String() string {
+	switch {
+	case p.wildcard && p.host == "":
+		return "*"
+	case p.wildcard:
+		return "*." + p.host
+	}
+
+	return p.host
+}
+
+// matches returns true if the pattern applies to the given host, which
+// shouldn't include a port.
+func (p hostPattern) matches(host string) bool {
+	host = normalizeDomain(host)
+
+	switch {
+	case p.wildcard && p.host == "":
+		return true
+	case p.wildcard:
+		return host == p.host || strings.HasSuffix(host, "."+p.host)
+	}
+
+	return host == p.host
+}
+
+// hostMatches returns true if any of the patterns apply to the given host.
+func hostMatches(patterns []hostPattern, host string) bool {
+	for _, pattern := range patterns {
+		if pattern.matches(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ignoreRule is a compiled entry from an ignore list. Entries are a host
+// pattern, optionally followed by a path regex, like "example.com/wiki/.*".
+// Path regexes must match the whole path, starting from the first "/". Since
+// entries are often copied from a browser, an http:// or https:// prefix is
+// allowed and dropped.
+type ignoreRule struct {
+	hostPattern
+
+	rawPath string
+	path    *regexp.Regexp
+}
+
+func compileIgnoreRule(raw string) (ignoreRule, error) {
+	var ret ignoreRule
+
+	host, path, hasPath := strings.Cut(trimHTTPScheme(strings.TrimSpace(raw)), "/")
+
+	var err error
+	ret.hostPattern, err = compileHostPattern(host)
+	if err != nil {
+		return ret, fmt.Errorf("invalid ignore pattern %q: %w", raw, err)
+	}
+
+	if hasPath {
+		// Check the regex on its own first so errors refer to what was
+		// actually written.
+		if _, err := regexp.Compile(path); err != nil {
+			return ret, fmt.Errorf("invalid ignore pattern %q: %w", raw, err)
+		}
+
+		re, err := regexp.Compile("^/(?:" + path + ")$")
+		if err != nil {
+			return ret, fmt.Errorf("invalid ignore pattern %q: %w", raw, err)
+		}
+		ret.rawPath = path
+		ret.path = re
+	}
+
+	return ret, nil
+}
+
+// trimHTTPScheme removes an http:// or https:// prefix from an ignore list
+// entry.
+func trimHTTPScheme(raw string) string {
+	for _, scheme := range []string{"https://", "http://"} {
+		if len(raw) >= len(scheme) && strings.EqualFold(raw[:len(scheme)], scheme) {
+			return raw[len(scheme):]
+		}
+	}
+
+	return raw
+}
+
+// String returns the rule in a normalized form, which compiles to the same
+// rule.
+func (r ignoreRule) String() string {
+	ret := r.hostPattern.String()
+
+	if r.path != nil {
+		ret += "/" + r.rawPath
+	}
+
+	return ret
+}
+
+// normalizeIgnorePattern checks an ignore list entry and returns it in a
+// normalized form, so the same entry can be found again later.
+func normalizeIgnorePattern(raw string) (string, error) {
+	rule, err := compileIgnoreRule(raw)
+	if err != nil {
+		return "", err
+	}
+
+	return rule.String(), nil
+}
+
+// compileIgnoreRules compiles a whole ignore list.
+func compileIgnoreRules(raw []string) ([]ignoreRule, error) {
+	var ret []ignoreRule
+	for _, entry := range raw {
+		rule, err := compileIgnoreRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, rule)
+	}
+
+	return ret, nil
+}
+
+// matches returns true if the rule applies to the given URL.
+func (r ignoreRule) matches(u *url.URL) bool {
+	if !r.hostPattern.matches(u.Hostname()) {
+		return false
+	}
+
+	if r.path == nil {
+		return true
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	return r.path.MatchString(path)
+}
+
+// normalizeDomain lowercases a host and strips any leading "www.".
+func normalizeDomain(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// ignoreMatches returns true if any of the rules apply to the given URL.
+func ignoreMatches(rules []ignoreRule, u *url.URL) bool {
+	for _, rule := range rules {
+		if rule.matches(u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ignoresURL returns true if a raw URL from a message matches the global
+// ignore list or the channel's, either as it was written or in its canonical
+// form, after any redirect wrappers are removed. URLs which can't be parsed
+// are left for lookupURL to deal with.
+func (c *Client) ignoresURL(policy ChannelPolicy, raw string) bool {
+	u, err := parseRawURL(raw)
+	if err != nil {
+		return false
+	}
+
+	canonical := *unwrapURL(u)
+	canonicalizeURL(&canonical)
+
+	for _, u := range []*url.URL{u, &canonical} {
+		if ignoreMatches(c.ignoreRules, u) || ignoreMatches(policy.ignoreRules, u) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package url
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		url     string
+		match   bool
+	}{
+		{"example.com", "https://example.com/anything", true},
+		{"example.com", "https://WWW.Example.com", true},
+		{"example.com", "https://sub.example.com", false},
+		{"example.com", "https://notexample.com", false},
+		{"*.example.com", "https://example.com", true},
+		{"*.example.com", "https://a.b.example.com/x", true},
+		{"*.example.com", "https://badexample.com", false},
+		{"*", "https://anything.test/x", true},
+		{"example.com/wiki/.*", "https://example.com/wiki/Page", true},
+		{"example.com/wiki/.*", "https://example.com/blog/wiki/Page", false},
+		{"example.com/wiki/.*", "https://example.com:8080/wiki/Page", true},
+		{"example.com/", "https://example.com/", true},
+		{"example.com/", "https://example.com/page", false},
+		{"*/admin(/.*)?", "https://intranet.test/admin", true},
+		{"*/admin(/.*)?", "https://intranet.test/administrator", false},
+		{"*.ci.test/job/[0-9]+", "https://build.ci.test/job/42", true},
+		{"*.ci.test/job/[0-9]+", "https://build.ci.test/job/latest", false},
+		{"https://example.com", "https://example.com/anything", true},
+		{"HTTP://example.com/wiki/.*", "https://example.com/wiki/Page", true},
+		{"https://example.com/wiki/.*", "https://example.com/blog", false},
+	}
+
+	for _, test := range tests {
+		rule, err := compileIgnoreRule(test.pattern)
+		require.NoError(t, err, test.pattern)
+		require.Equal(t, test.match, rule.matches(mustParseURL(t, test.url)), "%s on %s", test.pattern, test.url)
+	}
+
+	for _, pattern := range []string{"", "/path", "ex*ample.com", "example.com/(", "example.com:8080", "ftp://example.com", "https:", "https://"} {
+		_, err := compileIgnoreRule(pattern)
+		require.Error(t, err, pattern)
+	}
+
+	normalized, err := normalizeIgnorePattern("*.WWW.Example.COM/Wiki/.*")
+	require.NoError(t, err)
+	require.Equal(t, "*.www.example.com/Wiki/.*", normalized)
+
+	normalized, err = normalizeIgnorePattern("WWW.Example.COM")
+	require.NoError(t, err)
+	require.Equal(t, "example.com", normalized)
+
+	normalized, err = normalizeIgnorePattern("https://www.example.com/wiki/.*")
+	require.NoError(t, err)
+	require.Equal(t, "example.com/wiki/.*", normalized)
+
+	_, err = normalizeIgnorePattern("https:/example.com")
+	require.EqualError(t, err, `invalid ignore pattern "https:/example.com": hosts can't include a scheme or port`)
+}
+
+func TestMessageCallbackIgnoredURLs(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{
+		"/page": "page.html",
+	})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, fake := newTestClient(t)
+	p := &testProvider{host: host}
+	c.Register(p)
+
+	var err error
+	c.ignoreRules, err = compileIgnoreRules([]string{urlHost(server.URL) + "/private/.*"})
+	require.NoError(t, err)
+
+	require.NoError(t, c.SetChannelPolicy(testSource.ChannelId, ChannelPolicy{
+		IgnoredURLs: []string{urlHost(server.URL) + "/secret"},
+	}))
+
+	// Neither the provider nor the page title fallback see ignored links.
+	c.messageCallback(testSource, strings.Join([]string{
+		server.URL + "/private/handled",
+		server.URL + "/secret",
+		server.URL + "/handled",
+	}, " "), nil)
+	c.inFlight.Wait()
+
+	require.Equal(t, []string{"[Test] handled"}, fake.Messages())
+	require.Equal(t, int64(1), p.calls.Load())
+}
+
+func TestHostPattern(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		host    string
+		matches bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "WWW.Example.com", true},
+		{"example.com", "sub.example.com", false},
+		{"*.example.com", "example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "badexample.com", false},
+		{"*", "anything.test", true},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"::1", "::1", true},
+	}
+
+	for _, test := range tests {
+		pattern, err := compileHostPattern(test.pattern)
+		require.NoError(t, err)
+		require.Equal(t, test.matches, pattern.matches(test.host), "%s %s", test.pattern, test.host)
+	}
+
+	_, err := compileHostPatterns([]string{"example.com", "ex*ample.com"})
+	require.EqualError(t, err, `invalid host pattern "ex*ample.com": wildcards are only allowed at the start of the host`)
+
+	for _, pattern := range []string{"https://example.com", "example.com:443"} {
+		_, err := compileHostPattern(pattern)
+		require.EqualError(t, err, "hosts can't include a scheme or port", pattern)
+	}
+}
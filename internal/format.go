@@ -2,6 +2,7 @@ package internal
 
 import (
 	"math"
+	"reflect"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
@@ -28,7 +29,27 @@ func dateFormat(layout string, v interface{}) (string, error) {
 	return t.Format(layout), nil
 }
 
-func templatePluralize(count int, in interface{}) (string, error) {
+// toInt converts any integer (or pointer to an integer) to an int. Unlike
+// cast.ToIntE, this also handles named types like spotify.Numeric.
+func toInt(v interface{}) (int, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint()), nil
+	}
+
+	return cast.ToIntE(v)
+}
+
+func templatePluralize(rawCount interface{}, in interface{}) (string, error) {
+	count, err := toInt(rawCount)
+	if err != nil {
+		return "", err
+	}
+
 	word, err := cast.ToStringE(in)
 	if err != nil {
 		return "", err
@@ -43,7 +64,12 @@ func Pluralize(count int, word string) string {
 	return english.Plural(count, word, "")
 }
 
-func templatePluralizeWord(count int, in interface{}) (string, error) {
+func templatePluralizeWord(rawCount interface{}, in interface{}) (string, error) {
+	count, err := toInt(rawCount)
+	if err != nil {
+		return "", err
+	}
+
 	word, err := cast.ToStringE(in)
 	if err != nil {
 		return "", err
@@ -64,7 +90,12 @@ func PrettifyNumber(num int) string {
 
 var defaultSuffixes = []string{"B", "M", "K"}
 
-func templatePrettifySuffix(num int) (string, error) {
+func templatePrettifySuffix(rawNum interface{}) (string, error) {
+	num, err := toInt(rawNum)
+	if err != nil {
+		return "", err
+	}
+
 	return PrettifySuffix(num), nil
 }
 
@@ -93,3 +124,9 @@ func RawPrettifySuffix(num, blockSize float64, suffixes []string) string {
 
 	return humanize.Commaf(num)
 }
+
+// TimeAgo displays how long before now the given time was, such as "3 days
+// ago".
+func TimeAgo(then, now time.Time) string {
+	return humanize.RelTime(then, now, "ago", "from now")
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ErrBlockedAddress is returned when a request would connect to an internal
+// address while BlockPrivate is set.
+var ErrBlockedAddress = errors.New("refusing to connect to internal address")
+
+// blockedPrefixes are ranges which aren't covered by the netip helpers but
+// still shouldn't be reachable from user-provided URLs.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, which can map to any IPv4 address
+}
+
+// IsInternalAddress returns true if the address is loopback, link-local,
+// private, multicast or otherwise not a normal public address.
+func IsInternalAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsMulticast() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return true
+	}
+
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addressGuard refuses connections to internal addresses, other than the
+// ones which are explicitly allowed. Because it checks the address actually
+// being connected to, it also covers DNS names which resolve to internal
+// addresses and every hop of a redirect.
+type addressGuard struct {
+	allowedHosts    map[string]bool
+	allowedPrefixes []netip.Prefix
+}
+
+// newAddressGuard builds a guard from a list of allowed hosts, which can be
+// host names, IP addresses or CIDR ranges.
+func newAddressGuard(allowed []string) (*addressGuard, error) {
+	ret := &addressGuard{allowedHosts: make(map[string]bool)}
+
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			ret.allowedPrefixes = append(ret.allowedPrefixes, prefix.Masked())
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			ret.allowedPrefixes = append(ret.allowedPrefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		if entry == "" || strings.ContainsAny(entry, "/:") {
+			return nil, fmt.Errorf("invalid allowed host %q", entry)
+		}
+
+		ret.allowedHosts[entry] = true
+	}
+
+	return ret, nil
+}
+
+// ProxyHosts returns the host names of the proxies requests will be sent
+// through, either the given proxy URL or the HTTP_PROXY/HTTPS_PROXY
+// environment variables if it's empty.
+func ProxyHosts(proxy string) []string {
+	urls := []string{proxy}
+	if proxy == "" {
+		env := httpproxy.FromEnvironment()
+		urls = []string{env.HTTPProxy, env.HTTPSProxy}
+	}
+
+	var ret []string
+	for _, raw := range urls {
+		if raw == "" {
+			continue
+		}
+
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		if host := strings.ToLower(u.Hostname()); !slices.Contains(ret, host) {
+			ret = append(ret, host)
+		}
+	}
+
+	return ret
+}
+
+// allowProxies adds the hosts of any configured proxies to the allowlist,
+// since they're set up by whoever runs the plugin and may well be internal.
+// The guard only sees the connection to the proxy, so the proxy itself is
+// responsible for refusing internal destinations.
+func (g *addressGuard) allowProxies(proxy string) {
+	for _, host := range ProxyHosts(proxy) {
+		g.allowedHosts[host] = true
+	}
+}
+
+// check returns an error if the given resolved address isn't allowed.
+func (g *addressGuard) check(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range g.allowedPrefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if IsInternalAddress(addr) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addr)
+	}
+
+	return nil
+}
+
+// dialContext wraps a dialer so every connection is checked after the host
+// has been resolved, right before connecting.
+func (g *addressGuard) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	guarded := *dialer
+	guarded.Control = func(network, address string, _ syscall.RawConn) error {
+		return g.check(address)
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if g.allowedHosts[strings.ToLower(host)] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// newDialer returns a dialer with the same settings as the one used by
+// http.DefaultTransport.
+func newDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+}
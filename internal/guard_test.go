@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsInternalAddress(t *testing.T) {
+	var tests = []struct {
+		addr     string
+		internal bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"1.1.1.1", false},
+		{"93.184.216.34", false},
+		{"2606:4700:4700::1111", false},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.internal, IsInternalAddress(netip.MustParseAddr(test.addr)), test.addr)
+	}
+}
+
+func TestHTTPClientBlockPrivate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hello": "world"}`))
+	}))
+	defer server.Close()
+
+	// A public looking host which redirects to an internal one.
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+	defer redirect.Close()
+
+	get := func(config HTTPConfig, url string) error {
+		config.BlockPrivate = true
+
+		client, err := NewHTTPClient(config)
+		require.NoError(t, err)
+
+		var resp map[string]string
+		return GetJSON(context.Background(), client, url, &resp)
+	}
+
+	err := get(HTTPConfig{}, server.URL)
+	require.True(t, errors.Is(err, ErrBlockedAddress), "unexpected error: %v", err)
+
+	// Host names are checked after they're resolved.
+	localhostURL := strings.Replace(redirect.URL, "127.0.0.1", "localhost", 1)
+	err = get(HTTPConfig{}, localhostURL)
+	require.True(t, errors.Is(err, ErrBlockedAddress), "unexpected error: %v", err)
+
+	require.NoError(t, get(HTTPConfig{AllowedHosts: []string{"127.0.0.1"}}, server.URL))
+	require.NoError(t, get(HTTPConfig{AllowedHosts: []string{"127.0.0.0/8"}}, server.URL))
+
+	// Allowing a host by name doesn't allow everything it redirects to.
+	err = get(HTTPConfig{AllowedHosts: []string{"LocalHost"}}, localhostURL)
+	require.True(t, errors.Is(err, ErrBlockedAddress), "unexpected error: %v", err)
+
+	_, err = NewHTTPClient(HTTPConfig{BlockPrivate: true, AllowedHosts: []string{"http://example.com"}})
+	require.Error(t, err)
+}
+
+func TestProxyHosts(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("REQUEST_METHOD", "")
+	require.Empty(t, ProxyHosts(""))
+
+	require.Equal(t, []string{"proxy.example.com"}, ProxyHosts("http://Proxy.Example.com:3128"))
+	require.Equal(t, []string{"10.0.0.1"}, ProxyHosts("10.0.0.1:3128"))
+
+	t.Setenv("HTTP_PROXY", "http://squid.internal:3128")
+	t.Setenv("HTTPS_PROXY", "squid.internal:3129")
+	require.Equal(t, []string{"squid.internal"}, ProxyHosts(""))
+}
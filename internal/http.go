@@ -1,18 +1,214 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"time"
+)
 
-	//nolint:misspell
-	"github.com/unknwon/com"
+// Defaults used for any zero values in an HTTPConfig.
+const (
+	DefaultHTTPTimeout     = 5 * time.Second
+	DefaultHTTPUserAgent   = "seabird-url-plugin (+https://github.com/seabird-chat/seabird-url-plugin)"
+	DefaultHTTPMaxBodySize = 5 * 1024 * 1024
 )
 
+// HTTPConfig describes how outbound HTTP requests should be made. Zero values
+// are replaced with sensible defaults.
+type HTTPConfig struct {
+	// Timeout is the total time allowed for a single request, including
+	// reading the body.
+	Timeout time.Duration
+
+	// UserAgent is sent with every request.
+	UserAgent string
+
+	// MaxBodySize is the maximum number of bytes which will be read from any
+	// response body. Anything past this is silently dropped.
+	MaxBodySize int64
+
+	// Proxy is the URL of a proxy to send all requests through. If empty, the
+	// standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables are used.
+	Proxy string
+
+	// InsecureSkipVerify disables TLS certificate verification.
+	InsecureSkipVerify bool
+
+	// BlockPrivate refuses to connect to loopback, link-local, private,
+	// multicast and other internal addresses. It's checked for every
+	// connection, so it also applies to hosts which resolve to internal
+	// addresses and to redirects. Proxies are always allowed, and requests
+	// through them can only be checked by the proxy itself, so a proxy which
+	// forwards to internal addresses makes this ineffective.
+	BlockPrivate bool
+
+	// AllowedHosts are exempt from BlockPrivate. Entries can be host names,
+	// IP addresses or CIDR ranges.
+	AllowedHosts []string
+
+	// Transport overrides the underlying transport. This is mostly useful for
+	// tests which need to point requests at an httptest.Server.
+	Transport http.RoundTripper
+}
+
+// NewHTTPClient builds an *http.Client which applies all the settings in the
+// given config to every request.
+func NewHTTPClient(config HTTPConfig) (*http.Client, error) {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultHTTPTimeout
+	}
+
+	if config.UserAgent == "" {
+		config.UserAgent = DefaultHTTPUserAgent
+	}
+
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = DefaultHTTPMaxBodySize
+	}
+
+	inner := config.Transport
+	if inner == nil {
+		proxy := http.ProxyFromEnvironment
+		if config.Proxy != "" {
+			proxyURL, err := url.Parse(config.Proxy)
+			if err != nil {
+				return nil, err
+			}
+
+			proxy = http.ProxyURL(proxyURL)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		//nolint:gosec
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+		if config.BlockPrivate {
+			guard, err := newAddressGuard(config.AllowedHosts)
+			if err != nil {
+				return nil, err
+			}
+			guard.allowProxies(config.Proxy)
+
+			transport.DialContext = guard.dialContext(newDialer())
+		}
+
+		inner = transport
+	}
+
+	return &http.Client{
+		Transport: &httpTransport{
+			inner:       inner,
+			userAgent:   config.UserAgent,
+			maxBodySize: config.MaxBodySize,
+		},
+		Timeout: config.Timeout,
+	}, nil
+}
+
+// NewDefaultHTTPClient returns an *http.Client with the default settings.
+// Unlike http.DefaultClient, requests made with it have a timeout.
+func NewDefaultHTTPClient() *http.Client {
+	// The default config has no proxy or allowed hosts to parse, so this
+	// can't fail.
+	client, err := NewHTTPClient(HTTPConfig{})
+	if err != nil {
+		panic(err)
+	}
+
+	return client
+}
+
+// httpTransport wraps another RoundTripper, setting the User-Agent on all
+// outgoing requests and limiting how much of each response can be read.
+type httpTransport struct {
+	inner       http.RoundTripper
+	userAgent   string
+	maxBodySize int64
+}
+
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers aren't allowed to modify the request, so we need to make a
+	// copy before setting any headers.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &limitedReadCloser{
+		Reader: io.LimitReader(resp.Body, t.maxBodySize),
+		Closer: resp.Body,
+	}
+
+	return resp, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ErrNotFound is returned by GetJSON and PostJSON when the server responds
+// with a 404.
+var ErrNotFound = errors.New("not found")
+
 // PostJSON is a simple wrapper to post and get JSON from a given url.
-func PostJSON(url string, data, resp interface{}) error {
-	return com.HttpPostJSON(&http.Client{}, url, data, resp)
+func PostJSON(ctx context.Context, client *http.Client, url string, data, resp interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return doJSON(client, req, resp)
 }
 
 // GetJSON is a simple wrapper to get a json object from a given URL.
-func GetJSON(url string, resp interface{}) error {
-	return com.HttpGetJSON(&http.Client{}, url, resp)
+func GetJSON(ctx context.Context, client *http.Client, url string, resp interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	return doJSON(client, req, resp)
+}
+
+func doJSON(client *http.Client, req *http.Request, resp interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d from %s", r.StatusCode, req.URL)
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %w", req.URL, err)
+	}
+
+	return nil
 }
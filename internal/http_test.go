@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClientUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Write([]byte(`{"hello": "world"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPConfig{})
+	require.NoError(t, err)
+
+	var resp map[string]string
+	require.NoError(t, GetJSON(context.Background(), client, server.URL, &resp))
+	require.Equal(t, DefaultHTTPUserAgent, userAgent)
+	require.Equal(t, "world", resp["hello"])
+
+	client, err = NewHTTPClient(HTTPConfig{UserAgent: "test-agent"})
+	require.NoError(t, err)
+
+	require.NoError(t, GetJSON(context.Background(), client, server.URL, &resp))
+	require.Equal(t, "test-agent", userAgent)
+}
+
+func TestHTTPClientMaxBodySize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPConfig{MaxBodySize: 10})
+	require.NoError(t, err)
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Len(t, body, 10)
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := NewHTTPClient(HTTPConfig{Timeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	_, err = client.Get(server.URL)
+	require.Error(t, err)
+}
+
+func TestNewDefaultHTTPClient(t *testing.T) {
+	client := NewDefaultHTTPClient()
+	require.Equal(t, DefaultHTTPTimeout, client.Timeout)
+	require.IsType(t, &httpTransport{}, client.Transport)
+}
+
+func TestHTTPClientTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	// Tests need to be able to point any upstream host at a local server.
+	client, err := NewHTTPClient(HTTPConfig{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Host = req.URL.Host
+			req.URL.Scheme = "http"
+			req.URL.Host = strings.TrimPrefix(server.URL, "http://")
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	})
+	require.NoError(t, err)
+
+	resp, err := client.Get("https://example.com/hello")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "example.com", string(body))
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestGetJSONErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/broken":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		case "/invalid":
+			w.Write([]byte(`{"hello": `))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPConfig{})
+	require.NoError(t, err)
+
+	var resp map[string]string
+	require.ErrorIs(t, GetJSON(context.Background(), client, server.URL+"/missing", &resp), ErrNotFound)
+
+	err = GetJSON(context.Background(), client, server.URL+"/broken", &resp)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotFound)
+
+	require.Error(t, GetJSON(context.Background(), client, server.URL+"/invalid", &resp))
+}
+
+func TestGetJSONContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPConfig{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var resp map[string]string
+	require.ErrorIs(t, GetJSON(ctx, client, server.URL, &resp), context.DeadlineExceeded)
+}
@@ -15,6 +15,12 @@ import (
 // - dateFormat - takes one argument, the format of the date (in golang format)
 // - pluralize - takes one argument, the number of something this is describing
 func TemplateMustCompile(name, data string) *template.Template {
+	return template.Must(TemplateCompile(name, data))
+}
+
+// TemplateCompile is the same as TemplateMustCompile, but returns an error
+// rather than panicking. It is meant for user-provided templates.
+func TemplateCompile(name, data string) (*template.Template, error) {
 	ret := template.New(name)
 	ret.Funcs(template.FuncMap{
 		"dateFormat":     dateFormat,
@@ -23,13 +29,11 @@ func TemplateMustCompile(name, data string) *template.Template {
 		"prettifySuffix": templatePrettifySuffix,
 	})
 
-	template.Must(ret.Parse(strings.TrimSpace(data)))
-
-	return ret
+	return ret.Parse(strings.TrimSpace(data))
 }
 
-// RenderTemplate is a wrapper to render a template to a string.
-func RenderTemplate(t *template.Template, tag string, vars interface{}) (string, error) {
+// ExecuteTemplate is a wrapper to render a template to a string.
+func ExecuteTemplate(t *template.Template, vars interface{}) (string, error) {
 	b := bytes.NewBuffer(nil)
 
 	err := t.Execute(b, vars)
@@ -37,7 +41,7 @@ func RenderTemplate(t *template.Template, tag string, vars interface{}) (string,
 		return "", err
 	}
 
-	return tag + " " + b.String(), nil
+	return b.String(), nil
 }
 
 // AppendStr appends string to slice with no duplicates.
@@ -0,0 +1,342 @@
+package url
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChannelPolicy controls which lookups happen in a single channel.
+type ChannelPolicy struct {
+	// Providers enables or disables individual providers by name, matched
+	// case-insensitively. The generic page title fallback can be turned off
+	// with the "title" provider.
+	Providers map[string]bool
+
+	// AllowlistOnly disables every provider which isn't explicitly enabled in
+	// Providers, including the page title fallback.
+	AllowlistOnly bool
+
+	// QuietHours is a time range when no links are looked up at all.
+	QuietHours *QuietHours
+
+	// IgnoredURLs are never looked up in the channel, on top of the global
+	// ignore list. Entries can be exact hosts like "example.com", wildcards
+	// like "*.example.com", and either can be followed by a path regex, like
+	// "example.com/wiki/.*".
+	IgnoredURLs []string
+
+	// ignoreRules are compiled from IgnoredURLs.
+	ignoreRules []ignoreRule
+}
+
+// compile checks and compiles the policy's ignore list, normalizing the
+// entries so they can be compared with ones from the url command.
+func (p *ChannelPolicy) compile() error {
+	rules, err := compileIgnoreRules(p.IgnoredURLs)
+	if err != nil {
+		return err
+	}
+
+	p.IgnoredURLs = slices.Clone(p.IgnoredURLs)
+	for i, rule := range rules {
+		p.IgnoredURLs[i] = rule.String()
+	}
+	p.ignoreRules = rules
+
+	return nil
+}
+
+// providerEnabled returns true if the named provider should be used.
+func (p ChannelPolicy) providerEnabled(name string) bool {
+	if enabled, ok := p.Providers[strings.ToLower(name)]; ok {
+		return enabled
+	}
+
+	return !p.AllowlistOnly
+}
+
+// restricted returns true if the policy disables any providers.
+func (p ChannelPolicy) restricted() bool {
+	if p.AllowlistOnly {
+		return true
+	}
+
+	for _, enabled := range p.Providers {
+		if !enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// quiet returns true if no links should be looked up at the given time.
+func (p ChannelPolicy) quiet(now time.Time) bool {
+	return p.QuietHours != nil && p.QuietHours.contains(now)
+}
+
+// clone returns a copy of the policy which doesn't share any maps, with
+// provider names lowercased.
+func (p ChannelPolicy) clone() ChannelPolicy {
+	providers := make(map[string]bool, len(p.Providers))
+	for name, enabled := range p.Providers {
+		providers[strings.ToLower(name)] = enabled
+	}
+	p.Providers = providers
+
+	if p.QuietHours != nil {
+		quietHours := *p.QuietHours
+		p.QuietHours = &quietHours
+	}
+
+	p.IgnoredURLs = slices.Clone(p.IgnoredURLs)
+
+	return p
+}
+
+// QuietHours is a daily time range, given as offsets from midnight. If End is
+// before Start, the range wraps past midnight.
+type QuietHours struct {
+	Start time.Duration
+	End   time.Duration
+
+	// Location is the time zone the range is in. It defaults to UTC.
+	Location *time.Location
+}
+
+// ParseQuietHours parses a range like "22:00-07:30" in the given time zone.
+// A nil location means UTC.
+func ParseQuietHours(raw string, loc *time.Location) (*QuietHours, error) {
+	rawStart, rawEnd, ok := strings.Cut(raw, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid quiet hours %q: expected a range like 22:00-07:00", raw)
+	}
+
+	start, err := parseTimeOfDay(strings.TrimSpace(rawStart))
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours %q: %w", raw, err)
+	}
+
+	end, err := parseTimeOfDay(strings.TrimSpace(rawEnd))
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours %q: %w", raw, err)
+	}
+
+	return &QuietHours{Start: start, End: end, Location: loc}, nil
+}
+
+func parseTimeOfDay(raw string) (time.Duration, error) {
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, errors.New("times must look like 15:04")
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns true if the given time falls within the range.
+func (q QuietHours) contains(now time.Time) bool {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	now = now.In(loc)
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+
+	if q.Start <= q.End {
+		return offset >= q.Start && offset < q.End
+	}
+
+	return offset >= q.Start || offset < q.End
+}
+
+// String returns the range in the same format ParseQuietHours accepts.
+func (q QuietHours) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	return format(q.Start) + "-" + format(q.End)
+}
+
+// policyBucket is the Store bucket used to persist policies changed at
+// runtime.
+const policyBucket = "policies"
+
+// storedPolicy is how a ChannelPolicy is persisted. Quiet hours are stored as
+// text since a time.Location can't be serialized directly.
+type storedPolicy struct {
+	Providers     map[string]bool `json:",omitempty"`
+	AllowlistOnly bool            `json:",omitempty"`
+	QuietHours    string          `json:",omitempty"`
+	Timezone      string          `json:",omitempty"`
+	IgnoredURLs   []string        `json:",omitempty"`
+}
+
+func newStoredPolicy(p ChannelPolicy) storedPolicy {
+	ret := storedPolicy{
+		Providers:     p.Providers,
+		AllowlistOnly: p.AllowlistOnly,
+		IgnoredURLs:   p.IgnoredURLs,
+	}
+
+	if p.QuietHours != nil {
+		ret.QuietHours = p.QuietHours.String()
+		if p.QuietHours.Location != nil {
+			ret.Timezone = p.QuietHours.Location.String()
+		}
+	}
+
+	return ret
+}
+
+func (p storedPolicy) policy() (ChannelPolicy, error) {
+	ret := ChannelPolicy{
+		Providers:     p.Providers,
+		AllowlistOnly: p.AllowlistOnly,
+		IgnoredURLs:   p.IgnoredURLs,
+	}
+
+	if p.QuietHours != "" {
+		loc, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			return ret, err
+		}
+
+		ret.QuietHours, err = ParseQuietHours(p.QuietHours, loc)
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// channelPolicies holds the policy for every channel which has one. It can be
+// changed while the Client is running, and if there's a store, those changes
+// are persisted and override the config on the next start.
+type channelPolicies struct {
+	store *Store
+
+	lock     sync.RWMutex
+	policies map[string]ChannelPolicy
+}
+
+func newChannelPolicies(policies map[string]ChannelPolicy, store *Store) (*channelPolicies, error) {
+	ret := &channelPolicies{
+		store:    store,
+		policies: make(map[string]ChannelPolicy),
+	}
+
+	for channelID, policy := range policies {
+		if err := policy.compile(); err != nil {
+			return nil, fmt.Errorf("invalid policy for %s: %w", channelID, err)
+		}
+
+		ret.policies[channelID] = policy.clone()
+	}
+
+	if store == nil {
+		return ret, nil
+	}
+
+	err := store.forEach(policyBucket, func(channelID string, data []byte) error {
+		// A bad entry shouldn't stop the plugin from starting, so it's
+		// skipped and the config is used for that channel instead.
+		var stored storedPolicy
+		if err := json.Unmarshal(data, &stored); err != nil {
+			log.Printf("Ignoring invalid stored policy for %s: %s", channelID, err)
+			return nil
+		}
+
+		policy, err := stored.policy()
+		if err == nil {
+			err = policy.compile()
+		}
+		if err != nil {
+			log.Printf("Ignoring invalid stored policy for %s: %s", channelID, err)
+			return nil
+		}
+
+		ret.policies[channelID] = policy.clone()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (p *channelPolicies) get(channelID string) ChannelPolicy {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.policies[channelID].clone()
+}
+
+// update changes the policy for a channel with fn and persists the result.
+// If it's invalid or can't be persisted, nothing is changed.
+func (p *channelPolicies) update(channelID string, fn func(*ChannelPolicy)) (ChannelPolicy, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	policy := p.policies[channelID].clone()
+	fn(&policy)
+	policy = policy.clone()
+
+	if err := policy.compile(); err != nil {
+		return ChannelPolicy{}, err
+	}
+
+	if p.store != nil {
+		if err := p.store.put(policyBucket, channelID, newStoredPolicy(policy)); err != nil {
+			return ChannelPolicy{}, err
+		}
+	}
+
+	p.policies[channelID] = policy
+
+	return policy.clone(), nil
+}
+
+func (p *channelPolicies) all() map[string]ChannelPolicy {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	ret := make(map[string]ChannelPolicy, len(p.policies))
+	for channelID, policy := range p.policies {
+		ret[channelID] = policy.clone()
+	}
+
+	return ret
+}
+
+// ChannelPolicy returns the current policy for a channel. Channels without
+// one get the zero value, which allows everything.
+func (c *Client) ChannelPolicy(channelID string) ChannelPolicy {
+	return c.policies.get(channelID)
+}
+
+// SetChannelPolicy replaces the policy for a channel. It takes effect for the
+// next message and, if the Client has a store, is kept across restarts.
+func (c *Client) SetChannelPolicy(channelID string, policy ChannelPolicy) error {
+	_, err := c.policies.update(channelID, func(p *ChannelPolicy) {
+		*p = policy
+	})
+
+	return err
+}
+
+// ChannelPolicies returns the policies for every channel which has one.
+func (c *Client) ChannelPolicies() map[string]ChannelPolicy {
+	return c.policies.all()
+}
@@ -0,0 +1,104 @@
+package url
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuietHours(t *testing.T) {
+	loc := time.FixedZone("test", -8*60*60)
+
+	var tests = []struct {
+		raw   string
+		hour  int
+		quiet bool
+	}{
+		{"22:00-07:00", 23, true},
+		{"22:00-07:00", 3, true},
+		{"22:00-07:00", 7, false},
+		{"22:00-07:00", 12, false},
+		{"09:00-17:00", 9, true},
+		{"09:00-17:00", 17, false},
+		{"09:00-17:00", 20, false},
+	}
+
+	for _, test := range tests {
+		quietHours, err := ParseQuietHours(test.raw, loc)
+		require.NoError(t, err)
+		require.Equal(t, test.raw, quietHours.String())
+
+		now := time.Date(2024, 1, 1, test.hour, 30, 0, 0, loc)
+		require.Equal(t, test.quiet, quietHours.contains(now), "%s at %d:30", test.raw, test.hour)
+
+		// The range is always checked in its own time zone.
+		require.Equal(t, test.quiet, quietHours.contains(now.UTC()))
+	}
+
+	for _, raw := range []string{"", "22:00", "22:00-", "10pm-7am", "25:00-07:00"} {
+		_, err := ParseQuietHours(raw, nil)
+		require.Error(t, err, raw)
+	}
+}
+
+func TestChannelPolicyLookup(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{
+		"/handled": "page.html",
+	})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, _ := newTestClient(t)
+	c.cache = NewMemoryCache(0)
+	c.Register(&testProvider{host: host})
+
+	channel := testSource.GetChannelId()
+	raw := server.URL + "/handled"
+
+	// Fill the cache from a channel without a policy.
+	require.Equal(t, "Test", c.lookupURL(testSource, raw).Provider)
+
+	// Disabling a provider falls back to the page title, even if there's a
+	// cached preview from it.
+	require.NoError(t, c.SetChannelPolicy(channel, ChannelPolicy{Providers: map[string]bool{"Test": false}}))
+	require.Equal(t, titleProviderName, c.lookupURL(testSource, raw).Provider)
+
+	require.NoError(t, c.SetChannelPolicy(channel, ChannelPolicy{Providers: map[string]bool{"test": false, "title": false}}))
+	require.Nil(t, c.lookupURL(testSource, raw))
+
+	// In allowlist mode, only the providers which are turned on are used, but
+	// they can still use the cache.
+	require.NoError(t, c.SetChannelPolicy(channel, ChannelPolicy{AllowlistOnly: true, Providers: map[string]bool{"test": true}}))
+	require.Equal(t, "Test", c.lookupURL(testSource, raw).Provider)
+
+	require.NoError(t, c.SetChannelPolicy(channel, ChannelPolicy{AllowlistOnly: true}))
+	require.Nil(t, c.lookupURL(testSource, raw))
+
+	require.Equal(t, map[string]ChannelPolicy{
+		channel: {Providers: map[string]bool{}, AllowlistOnly: true},
+	}, c.ChannelPolicies())
+}
+
+func TestChannelPolicyQuietHours(t *testing.T) {
+	c, fake := newTestClient(t)
+	p := &testProvider{host: "example.com"}
+	c.Register(p)
+
+	now := time.Now().UTC()
+	start := time.Duration(now.Hour()) * time.Hour
+
+	require.NoError(t, c.SetChannelPolicy(testSource.GetChannelId(), ChannelPolicy{
+		QuietHours: &QuietHours{Start: start, End: start + time.Hour},
+	}))
+
+	c.messageCallback(testSource, "https://example.com/handled", nil)
+	c.inFlight.Wait()
+
+	// Unless the hour changed while the test was running, nothing should have
+	// been looked up.
+	if time.Now().UTC().Hour() == now.Hour() {
+		require.Zero(t, p.calls.Load())
+		require.Empty(t, fake.Messages())
+	}
+}
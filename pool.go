@@ -0,0 +1,268 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// DefaultWorkers is how many lookups can run at once.
+	DefaultWorkers = 8
+
+	// DefaultQueueSize is how many lookups can be waiting for a worker.
+	DefaultQueueSize = 100
+
+	// DefaultMaxURLsPerMessage is how many URLs are looked up from a single
+	// message.
+	DefaultMaxURLsPerMessage = 5
+
+	// DefaultMaxPerHost is how many lookups for the same host can run at
+	// once.
+	DefaultMaxPerHost = 2
+
+	// DefaultMaxQueuedPerChannel is how many lookups from a single channel
+	// can be waiting for a worker.
+	DefaultMaxQueuedPerChannel = 20
+)
+
+// WorkerConfig controls how lookups are scheduled. Zero values use the
+// defaults.
+type WorkerConfig struct {
+	Workers             int
+	QueueSize           int
+	MaxURLsPerMessage   int
+	MaxPerHost          int
+	MaxQueuedPerChannel int
+}
+
+func (c WorkerConfig) validate() error {
+	if c.Workers < 0 || c.QueueSize < 0 || c.MaxURLsPerMessage < 0 || c.MaxPerHost < 0 || c.MaxQueuedPerChannel < 0 {
+		return errors.New("worker settings must not be negative")
+	}
+
+	return nil
+}
+
+func (c WorkerConfig) withDefaults() WorkerConfig {
+	if c.Workers == 0 {
+		c.Workers = DefaultWorkers
+	}
+
+	if c.QueueSize == 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+
+	if c.MaxURLsPerMessage == 0 {
+		c.MaxURLsPerMessage = DefaultMaxURLsPerMessage
+	}
+
+	if c.MaxPerHost == 0 {
+		c.MaxPerHost = DefaultMaxPerHost
+	}
+
+	if c.MaxQueuedPerChannel == 0 {
+		c.MaxQueuedPerChannel = DefaultMaxQueuedPerChannel
+	}
+
+	return c
+}
+
+// PoolStats describes the state of the worker pool.
+type PoolStats struct {
+	// Queued is the number of jobs currently waiting for a worker, and
+	// MaxQueued is the most there have ever been at once.
+	Queued    int
+	MaxQueued int
+
+	// Running is the number of jobs currently running.
+	Running int
+
+	// Completed and Dropped count jobs which have finished, or which were
+	// rejected because the queue was full.
+	Completed int64
+	Dropped   int64
+}
+
+// poolJob is a single unit of work. Jobs for the same host are limited in
+// how many run at once, and jobs for the same channel are limited in how
+// many can be queued.
+type poolJob struct {
+	channel string
+	host    string
+	fn      func()
+}
+
+// workerPool runs jobs on a fixed number of goroutines.
+type workerPool struct {
+	config WorkerConfig
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queue    []*poolJob
+	running  map[string]int
+	queued   map[string]int
+	stats    PoolStats
+	stopped  bool
+	finished sync.WaitGroup
+}
+
+func newWorkerPool(config WorkerConfig) *workerPool {
+	p := &workerPool{
+		config:  config,
+		running: make(map[string]int),
+		queued:  make(map[string]int),
+	}
+	p.cond = sync.NewCond(&p.lock)
+
+	for i := 0; i < config.Workers; i++ {
+		p.finished.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// submit queues a job. It returns false if the job was dropped because the
+// queue, or the channel's share of it, is full.
+func (p *workerPool) submit(job *poolJob) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stopped || len(p.queue) >= p.config.QueueSize || p.queued[job.channel] >= p.config.MaxQueuedPerChannel {
+		p.stats.Dropped++
+		return false
+	}
+
+	p.queue = append(p.queue, job)
+	p.queued[job.channel]++
+
+	p.stats.Queued = len(p.queue)
+	p.stats.MaxQueued = max(p.stats.MaxQueued, p.stats.Queued)
+
+	p.cond.Broadcast()
+
+	return true
+}
+
+// next waits for a job which can run without going over its host's limit.
+// It returns nil once the pool is stopped and there's nothing left to do.
+func (p *workerPool) next() *poolJob {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for {
+		for i, job := range p.queue {
+			if job.host != "" && p.running[job.host] >= p.config.MaxPerHost {
+				continue
+			}
+
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			p.queued[job.channel]--
+			if p.queued[job.channel] == 0 {
+				delete(p.queued, job.channel)
+			}
+			p.running[job.host]++
+
+			p.stats.Queued = len(p.queue)
+			p.stats.Running++
+
+			return job
+		}
+
+		if p.stopped && len(p.queue) == 0 {
+			return nil
+		}
+
+		p.cond.Wait()
+	}
+}
+
+// done marks a job returned from next as finished.
+func (p *workerPool) done(job *poolJob) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.running[job.host]--
+	if p.running[job.host] == 0 {
+		delete(p.running, job.host)
+	}
+
+	p.stats.Running--
+	p.stats.Completed++
+
+	// A job waiting on this host may be able to run now.
+	p.cond.Broadcast()
+}
+
+func (p *workerPool) work() {
+	defer p.finished.Done()
+
+	for {
+		job := p.next()
+		if job == nil {
+			return
+		}
+
+		job.fn()
+		p.done(job)
+	}
+}
+
+// stop waits for all queued jobs to finish and then stops the workers.
+func (p *workerPool) stop() {
+	p.lock.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.lock.Unlock()
+
+	p.finished.Wait()
+}
+
+func (p *workerPool) snapshot() PoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.stats
+}
+
+// dispatch runs fn on the worker pool, or in its own goroutine if the Client
+// doesn't have one. Like spawn, Run waits for it before returning. It returns
+// false if the job was dropped.
+func (c *Client) dispatch(channel, host string, fn func()) bool {
+	if c.pool == nil {
+		c.spawn(fn)
+		return true
+	}
+
+	c.inFlight.Add(1)
+
+	ok := c.pool.submit(&poolJob{
+		channel: channel,
+		host:    host,
+		fn: func() {
+			defer c.inFlight.Done()
+			fn()
+		},
+	})
+	if !ok {
+		c.inFlight.Done()
+	}
+
+	return ok
+}
+
+// String describes the pool's queue and counters on a single line.
+func (s PoolStats) String() string {
+	return fmt.Sprintf("queue: %d queued (max %d), %d running, %d completed, %d dropped",
+		s.Queued, s.MaxQueued, s.Running, s.Completed, s.Dropped)
+}
+
+// PoolStats returns a snapshot of the worker pool's queue and counters.
+func (c *Client) PoolStats() PoolStats {
+	if c.pool == nil {
+		return PoolStats{}
+	}
+
+	return c.pool.snapshot()
+}
@@ -0,0 +1,160 @@
+package url
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPoolHostLimit(t *testing.T) {
+	pool := newWorkerPool(WorkerConfig{
+		Workers:             4,
+		QueueSize:           10,
+		MaxPerHost:          1,
+		MaxQueuedPerChannel: 10,
+	})
+
+	var (
+		lock     sync.Mutex
+		running  = make(map[string]int)
+		maxSeen  = make(map[string]int)
+		finished sync.WaitGroup
+	)
+
+	for i := 0; i < 3; i++ {
+		for _, host := range []string{"a.example.com", "b.example.com"} {
+			finished.Add(1)
+			ok := pool.submit(&poolJob{
+				channel: "irc://test/#channel",
+				host:    host,
+				fn: func() {
+					defer finished.Done()
+
+					lock.Lock()
+					running[host]++
+					maxSeen[host] = max(maxSeen[host], running[host])
+					lock.Unlock()
+
+					time.Sleep(5 * time.Millisecond)
+
+					lock.Lock()
+					running[host]--
+					lock.Unlock()
+				},
+			})
+			require.True(t, ok)
+		}
+	}
+
+	finished.Wait()
+	pool.stop()
+
+	require.Equal(t, map[string]int{"a.example.com": 1, "b.example.com": 1}, maxSeen)
+
+	stats := pool.snapshot()
+	require.Equal(t, int64(6), stats.Completed)
+	require.Equal(t, 0, stats.Queued)
+	require.Equal(t, 0, stats.Running)
+	require.Positive(t, stats.MaxQueued)
+}
+
+func TestWorkerPoolDrops(t *testing.T) {
+	pool := newWorkerPool(WorkerConfig{
+		Workers:             1,
+		QueueSize:           3,
+		MaxPerHost:          1,
+		MaxQueuedPerChannel: 2,
+	})
+
+	// Block the only worker so everything else stays queued.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	require.True(t, pool.submit(&poolJob{channel: "busy", fn: func() {
+		close(started)
+		<-release
+	}}))
+	<-started
+
+	var ran atomic.Int64
+	job := func(channel string) *poolJob {
+		return &poolJob{channel: channel, fn: func() { ran.Add(1) }}
+	}
+
+	require.True(t, pool.submit(job("busy")))
+	require.True(t, pool.submit(job("busy")))
+
+	// The busy channel has used up its share of the queue, but others can
+	// still get in until the queue itself is full.
+	require.False(t, pool.submit(job("busy")))
+	require.True(t, pool.submit(job("quiet")))
+	require.False(t, pool.submit(job("other")))
+
+	stats := pool.snapshot()
+	require.Equal(t, 3, stats.Queued)
+	require.Equal(t, 1, stats.Running)
+	require.Equal(t, int64(2), stats.Dropped)
+
+	// Stopping lets the queue drain first.
+	close(release)
+	pool.stop()
+	require.Equal(t, int64(3), ran.Load())
+	require.False(t, pool.submit(job("quiet")))
+}
+
+func TestMessageCallbackURLCap(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, fake := newTestClient(t)
+	p := &testProvider{host: host}
+	c.Register(p)
+
+	// Lookups for the same host run one at a time.
+	c.pool = newWorkerPool(WorkerConfig{Workers: 2, MaxPerHost: 1}.withDefaults())
+	c.maxURLsPerMessage = 2
+
+	var urls []string
+	for i := 0; i < 5; i++ {
+		urls = append(urls, fmt.Sprintf("%s/handled?n=%d", server.URL, i))
+	}
+
+	c.messageCallback(testSource, strings.Join(urls, " "), nil)
+	c.inFlight.Wait()
+	c.pool.stop()
+
+	require.Equal(t, []string{"[Test] handled", "[Test] handled"}, fake.Messages())
+	require.Equal(t, int64(2), c.PoolStats().Completed)
+
+	// Operators can see how busy the queue has been.
+	reply, err := c.urlCommand(testSource, "stats")
+	require.NoError(t, err)
+	require.Regexp(t, `^queue: 0 queued \(max \d\), 0 running, 2 completed, 0 dropped; lookups: Test 2 handled`, reply)
+}
+
+// Make sure message callbacks also run on the pool.
+func TestMessageCallbackPool(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.pool = newWorkerPool(WorkerConfig{}.withDefaults())
+
+	var calls atomic.Int64
+	c.messageCallbacks = append(c.messageCallbacks, registeredMessageCallback{
+		provider: "Test",
+		callback: func(ctx context.Context, c *Client, source *pb.ChannelSource, text string) {
+			calls.Add(1)
+		},
+	})
+
+	c.messageCallback(testSource, "no links here", nil)
+	c.inFlight.Wait()
+	c.pool.stop()
+
+	require.Equal(t, int64(1), calls.Load())
+	require.Equal(t, int64(1), c.PoolStats().Completed)
+}
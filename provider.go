@@ -1,22 +1,168 @@
 package url
 
 import (
+	"context"
+	"errors"
 	"net/url"
 
 	"github.com/seabird-chat/seabird-go/pb"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
-// URLCallback is a callback to be registered with the Client. It takes a
-// *url.URL representing the found url. It returns true if it was able to handle
-// that url and false otherwise.
-type URLCallback func(c *Client, source *pb.ChannelSource, u *url.URL) bool
+// Preview is the structured result of looking up a URL. Providers return
+// these rather than replying directly so the Client can decide how (and
+// whether) to render and send them.
+type Preview struct {
+	// Provider is the name of the provider which generated this preview, such
+	// as "Github". It is used as the prefix when rendering.
+	Provider string
+
+	// Title is the human-readable, single line description of the link.
+	Title string
+
+	// Fields are additional structured details about the link. They are not
+	// included when rendering to plain text.
+	Fields []PreviewField
+
+	// Annotations are short notes appended to the rendered preview, such as
+	// who first posted the link.
+	Annotations []string
+
+	// URL is the link this preview describes. If a provider leaves it empty,
+	// the Client fills it in with the URL which was looked up.
+	URL string
+
+	// Err is set if the lookup for a URL the provider recognized failed.
+	Err error `json:"-"`
+
+	// sent is set on previews which the Client shouldn't send. This is
+	// either because they came from adapted URLCallbacks, which have already
+	// replied on their own, or because there turned out to be nothing to
+	// show, like a short link to an ignored URL.
+	sent bool
+
+	// spoiler is set on previews of links which were posted in a spoiler, so
+	// they're sent in one too.
+	spoiler bool
+}
+
+// PreviewField is a single named detail in a Preview.
+type PreviewField struct {
+	Name  string
+	Value string
+}
+
+// Field returns the value of the named field, or an empty string if it isn't
+// set.
+func (p *Preview) Field(name string) string {
+	for _, field := range p.Fields {
+		if field.Name == name {
+			return field.Value
+		}
+	}
+
+	return ""
+}
+
+// errorPreview returns the Preview for a lookup which failed with the given
+// error. Because a 404 from an API generally means the URL doesn't point to
+// something the provider knows about, internal.ErrNotFound is treated as the
+// URL not being handled and nil is returned.
+func errorPreview(provider string, err error) *Preview {
+	if errors.Is(err, internal.ErrNotFound) {
+		return nil
+	}
+
+	return &Preview{
+		Provider: provider,
+		Err:      err,
+	}
+}
+
+// PreviewCallback is a callback to be registered with the Client. It takes a
+// *url.URL representing the found url and a context which is cancelled when
+// the provider's lookup timeout passes or the Client shuts down. It returns a
+// Preview if the url was recognized and nil otherwise. If the url was
+// recognized but the lookup failed, the returned Preview has Err set.
+type PreviewCallback func(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview
+
+// URLCallback is the original callback type. It takes a *url.URL representing
+// the found url and is responsible for replying on its own. It returns true if
+// it was able to handle that url and false otherwise. If the url was
+// recognized but the lookup failed, it returns an error.
+type URLCallback func(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) (bool, error)
 
 // MessageCallback is a callback to be registered with the Client. It takes an
-// event and allows the callback to do what it needs. Note that if any network
-// calls need to be made it is recommended to do that in a goroutine.
-type MessageCallback func(c *Client, source *pb.ChannelSource, text string)
+// event and allows the callback to do what it needs. Like PreviewCallback, the
+// context is cancelled when the provider's lookup timeout passes.
+type MessageCallback func(ctx context.Context, c *Client, source *pb.ChannelSource, text string)
 
+// Provider is a named collection of callbacks which can be registered with
+// the Client.
 type Provider interface {
+	Name() string
+	GetPreviewCallbacks() map[string]PreviewCallback
+	GetMessageCallback() MessageCallback
+}
+
+// LegacyProvider is the original provider interface, where URL callbacks
+// reply on their own. Use AdaptLegacyProvider to register one.
+type LegacyProvider interface {
 	GetCallbacks() map[string]URLCallback
 	GetMessageCallback() MessageCallback
 }
+
+// AdaptURLCallback wraps a URLCallback so it can be used as a
+// PreviewCallback. Because the URLCallback has already replied by the time
+// it returns, the resulting Preview is never rendered or sent again.
+func AdaptURLCallback(name string, cb URLCallback) PreviewCallback {
+	return func(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
+		ok, err := cb(ctx, c, source, u)
+		if err != nil {
+			return &Preview{
+				Provider: name,
+				URL:      u.String(),
+				Err:      err,
+			}
+		}
+
+		if !ok {
+			return nil
+		}
+
+		return &Preview{
+			Provider: name,
+			URL:      u.String(),
+			sent:     true,
+		}
+	}
+}
+
+// AdaptLegacyProvider wraps a LegacyProvider so it can be registered with
+// the Client under the given name.
+func AdaptLegacyProvider(name string, p LegacyProvider) Provider {
+	return &legacyProvider{name: name, inner: p}
+}
+
+type legacyProvider struct {
+	name  string
+	inner LegacyProvider
+}
+
+func (p *legacyProvider) Name() string {
+	return p.name
+}
+
+func (p *legacyProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	ret := make(map[string]PreviewCallback)
+	for host, cb := range p.inner.GetCallbacks() {
+		ret[host] = AdaptURLCallback(p.name, cb)
+	}
+
+	return ret
+}
+
+func (p *legacyProvider) GetMessageCallback() MessageCallback {
+	return p.inner.GetMessageCallback()
+}
@@ -0,0 +1,118 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
+)
+
+type testLegacyProvider struct{}
+
+func (p *testLegacyProvider) GetCallbacks() map[string]URLCallback {
+	return map[string]URLCallback{
+		"example.com": func(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) (bool, error) {
+			switch u.Path {
+			case "/handled":
+				c.Reply(source, "legacy reply")
+				return true, nil
+			case "/failed":
+				return false, errors.New("lookup failed")
+			}
+
+			return false, nil
+		},
+	}
+}
+
+func (p *testLegacyProvider) GetMessageCallback() MessageCallback {
+	return nil
+}
+
+func TestAdaptLegacyProvider(t *testing.T) {
+	p := AdaptLegacyProvider("Legacy", &testLegacyProvider{})
+	require.Equal(t, "Legacy", p.Name())
+
+	cb := p.GetPreviewCallbacks()["example.com"]
+	require.NotNil(t, cb)
+
+	c, fake := newTestClient(t)
+
+	require.Nil(t, cb(context.Background(), c, testSource, mustParseURL(t, "https://example.com/other")))
+	require.Empty(t, fake.Messages())
+
+	preview := cb(context.Background(), c, testSource, mustParseURL(t, "https://example.com/failed"))
+	require.NotNil(t, preview)
+	require.Error(t, preview.Err)
+	require.Empty(t, fake.Messages())
+
+	preview = cb(context.Background(), c, testSource, mustParseURL(t, "https://example.com/handled"))
+	require.NotNil(t, preview)
+	require.Equal(t, "Legacy", preview.Provider)
+	require.Equal(t, []string{"legacy reply"}, fake.Messages())
+
+	// The legacy callback already replied, so the preview must not be sent
+	// again.
+	require.NoError(t, c.ReplyPreview(testSource, preview))
+	require.Equal(t, []string{"legacy reply"}, fake.Messages())
+}
+
+func TestReplyPreview(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	require.NoError(t, c.ReplyPreview(testSource, &Preview{Provider: "Github", Title: "belak/go-seabird"}))
+	require.NoError(t, c.ReplyPreview(testSource, &Preview{Provider: titleProviderName, Title: "Example Domain"}))
+	require.NoError(t, c.ReplyPreview(testSource, &Preview{Provider: "Github", Err: errors.New("lookup failed")}))
+	require.NoError(t, c.ReplyPreview(testSource, nil))
+
+	require.Equal(t, []string{
+		"[Github] belak/go-seabird",
+		"Title: Example Domain",
+	}, fake.Messages())
+}
+
+func TestReplyPreviewTemplate(t *testing.T) {
+	c, fake := newTestClient(t)
+
+	var err error
+	c.templates, err = compileTemplates(map[string]string{
+		"GitHub": `{{ .Title }} ({{ .Field "stars" }} stars)`,
+		"broken": `{{ .Missing }}`,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.ReplyPreview(testSource, &Preview{
+		Provider:    "Github",
+		Title:       "belak/go-seabird",
+		Fields:      []PreviewField{{Name: "stars", Value: "42"}},
+		Annotations: []string{"(first posted by bob 3 days ago)"},
+	}))
+
+	// Templates which fail to render fall back to the default format.
+	require.NoError(t, c.ReplyPreview(testSource, &Preview{Provider: "Broken", Title: "title"}))
+
+	require.Equal(t, []string{
+		"belak/go-seabird (42 stars) (first posted by bob 3 days ago)",
+		"[Broken] title",
+	}, fake.Messages())
+}
+
+func TestProviderDefaultHTTPClient(t *testing.T) {
+	// Providers used without a client shouldn't fall back to one which can
+	// hang forever.
+	for name, client := range map[string]*http.Client{
+		"bitbucket": NewBitbucketProvider(BitbucketOptions{}).http,
+		"reddit":    NewRedditProvider(RedditOptions{}).http,
+		"twitter":   NewTwitterProvider(TwitterOptions{}).http,
+		"xkcd":      NewXKCDProvider(XKCDOptions{}).http,
+		"youtube":   NewYoutubeProvider(YoutubeOptions{}).http,
+	} {
+		require.Equal(t, internal.DefaultHTTPTimeout, client.Timeout, name)
+	}
+}
@@ -0,0 +1,268 @@
+package url
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+)
+
+// rateLimitNotice is sent when a message has links skipped because of a rate
+// limit.
+const rateLimitNotice = "Too many links, skipping."
+
+// rateLimitPruneSize is how many buckets are kept before sweeping for ones
+// which have refilled.
+const rateLimitPruneSize = 1000
+
+// RateLimit allows Limit lookups every Per, with bursts of up to Limit. A
+// zero Limit means there is no limit.
+type RateLimit struct {
+	Limit int
+	Per   time.Duration
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Limit > 0 && l.Per > 0
+}
+
+func (l RateLimit) validate() error {
+	if l.Limit < 0 || l.Per < 0 {
+		return errors.New("rate limits must not be negative")
+	}
+
+	if l.Limit > 0 && l.Per == 0 {
+		return errors.New("rate limits need a period")
+	}
+
+	return nil
+}
+
+// RateLimitConfig controls how many links are looked up over time. Each
+// limit is tracked separately, and a link is only looked up if none of them
+// have run out.
+type RateLimitConfig struct {
+	// Channel limits lookups for each channel.
+	Channel RateLimit
+
+	// User limits lookups for links posted by each user.
+	User RateLimit
+
+	// Host limits lookups for each upstream host, across all channels.
+	Host RateLimit
+
+	// NoticeInterval is the minimum time between "too many links" notices in
+	// a channel. It defaults to the longest period of the limits.
+	NoticeInterval time.Duration
+}
+
+func (c RateLimitConfig) validate() error {
+	for _, limit := range []RateLimit{c.Channel, c.User, c.Host} {
+		if err := limit.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.NoticeInterval < 0 {
+		return errors.New("rate limit notice interval must not be negative")
+	}
+
+	return nil
+}
+
+func (c RateLimitConfig) enabled() bool {
+	return c.Channel.enabled() || c.User.enabled() || c.Host.enabled()
+}
+
+func (c RateLimitConfig) withDefaults() RateLimitConfig {
+	if c.NoticeInterval == 0 {
+		c.NoticeInterval = max(c.Channel.Per, c.User.Per, c.Host.Per)
+	}
+
+	return c
+}
+
+// tokenBucket holds the tokens left for a single key and when it was last
+// refilled.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens earned since the bucket was last updated.
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	elapsed := now.Sub(b.updated)
+	if elapsed <= 0 {
+		return
+	}
+
+	b.tokens = min(float64(limit.Limit), b.tokens+float64(limit.Limit)*elapsed.Seconds()/limit.Per.Seconds())
+	b.updated = now
+}
+
+// rateLimiter tracks a token bucket for every channel, user and host which
+// has had a link looked up.
+type rateLimiter struct {
+	config RateLimitConfig
+
+	lock    sync.Mutex
+	buckets map[rateLimitKey]*tokenBucket
+	notices map[string]time.Time
+}
+
+// rateLimitKey identifies a bucket. Kind is one of "channel", "user" or
+// "host".
+type rateLimitKey struct {
+	kind string
+	name string
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config:  config.withDefaults(),
+		buckets: make(map[rateLimitKey]*tokenBucket),
+		notices: make(map[string]time.Time),
+	}
+}
+
+// allow takes a token from the channel, user and host buckets if all of them
+// have one left. Otherwise, it takes nothing and returns false.
+func (l *rateLimiter) allow(channel, user, host string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	type check struct {
+		limit RateLimit
+		key   rateLimitKey
+	}
+
+	checks := []check{
+		{l.config.Channel, rateLimitKey{"channel", channel}},
+		{l.config.User, rateLimitKey{"user", user}},
+		{l.config.Host, rateLimitKey{"host", host}},
+	}
+
+	var buckets []*tokenBucket
+	for _, check := range checks {
+		if !check.limit.enabled() || check.key.name == "" {
+			continue
+		}
+
+		bucket := l.bucket(check.key, check.limit, now)
+		if bucket.tokens < 1 {
+			return false
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+
+	return true
+}
+
+// bucket returns the refilled bucket for a key, creating a full one if it
+// doesn't exist yet.
+func (l *rateLimiter) bucket(key rateLimitKey, limit RateLimit, now time.Time) *tokenBucket {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimitPruneSize {
+			l.prune(now)
+		}
+
+		bucket = &tokenBucket{tokens: float64(limit.Limit), updated: now}
+		l.buckets[key] = bucket
+
+		return bucket
+	}
+
+	bucket.refill(limit, now)
+
+	return bucket
+}
+
+// prune removes buckets which have refilled completely, since they're the
+// same as a new one.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		limit := l.limit(key.kind)
+		if !limit.enabled() || now.Sub(bucket.updated) >= limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+
+	for channel, sent := range l.notices {
+		if now.Sub(sent) >= l.config.NoticeInterval {
+			delete(l.notices, channel)
+		}
+	}
+}
+
+func (l *rateLimiter) limit(kind string) RateLimit {
+	switch kind {
+	case "channel":
+		return l.config.Channel
+	case "user":
+		return l.config.User
+	case "host":
+		return l.config.Host
+	}
+
+	return RateLimit{}
+}
+
+// notify returns true if a "too many links" notice should be sent to the
+// channel, which is at most once per NoticeInterval.
+func (l *rateLimiter) notify(channel string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if sent, ok := l.notices[channel]; ok && now.Sub(sent) < l.config.NoticeInterval {
+		return false
+	}
+
+	l.notices[channel] = now
+
+	return true
+}
+
+// rateLimitURLs drops any URLs from a message which would go over a rate
+// limit, sending a notice if any were skipped.
+func (c *Client) rateLimitURLs(source *pb.ChannelSource, rawurls []string) []string {
+	if c.limiter == nil {
+		return rawurls
+	}
+
+	channel := source.GetChannelId()
+	user := source.GetUser().GetId()
+	now := time.Now()
+
+	var ret, skipped []string
+	for _, raw := range rawurls {
+		if !c.limiter.allow(channel, user, urlHost(raw), now) {
+			skipped = append(skipped, raw)
+			continue
+		}
+
+		ret = append(ret, raw)
+	}
+
+	if len(skipped) > 0 {
+		log.Printf("Rate limited %d of %d URLs in message to %s", len(skipped), len(rawurls), channel)
+		c.recordSkipped(source, skipped)
+
+		// This runs on the event stream, so sending the notice can't be
+		// allowed to hold it up.
+		if c.limiter.notify(channel, now) {
+			c.spawn(func() {
+				c.Reply(source, rateLimitNotice)
+			})
+		}
+	}
+
+	return ret
+}
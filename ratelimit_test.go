@@ -0,0 +1,106 @@
+package url
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{
+		Channel: RateLimit{Limit: 3, Per: time.Minute},
+		User:    RateLimit{Limit: 2, Per: time.Minute},
+	})
+
+	now := time.Now()
+
+	// Each user gets their own bucket, but they share the channel's.
+	require.True(t, limiter.allow("#a", "alice", "example.com", now))
+	require.True(t, limiter.allow("#a", "alice", "example.com", now))
+	require.False(t, limiter.allow("#a", "alice", "example.com", now))
+	require.True(t, limiter.allow("#a", "bob", "example.com", now))
+	require.False(t, limiter.allow("#a", "bob", "example.com", now))
+
+	// A rejected lookup doesn't use up any tokens, so bob can still post in
+	// another channel.
+	require.True(t, limiter.allow("#b", "bob", "example.com", now))
+
+	// Tokens come back over time.
+	require.False(t, limiter.allow("#a", "carol", "example.com", now.Add(10*time.Second)))
+	require.True(t, limiter.allow("#a", "carol", "example.com", now.Add(20*time.Second)))
+
+	// Notices are only sent once per interval.
+	require.True(t, limiter.notify("#a", now))
+	require.False(t, limiter.notify("#a", now.Add(30*time.Second)))
+	require.True(t, limiter.notify("#b", now))
+	require.True(t, limiter.notify("#a", now.Add(time.Minute)))
+}
+
+func TestRateLimiterHost(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{
+		Host: RateLimit{Limit: 1, Per: time.Hour},
+	})
+
+	now := time.Now()
+
+	require.True(t, limiter.allow("#a", "alice", "example.com", now))
+	require.False(t, limiter.allow("#b", "bob", "example.com", now))
+	require.True(t, limiter.allow("#b", "bob", "example.org", now))
+}
+
+func TestMessageCallbackRateLimit(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, fake := newTestClient(t)
+	c.Register(&testProvider{host: host})
+	c.limiter = newRateLimiter(RateLimitConfig{
+		Channel: RateLimit{Limit: 2, Per: time.Hour},
+	})
+
+	var urls []string
+	for i := 0; i < 4; i++ {
+		urls = append(urls, fmt.Sprintf("%s/handled?n=%d", server.URL, i))
+	}
+
+	c.messageCallback(testSource, strings.Join(urls, " "), nil)
+	c.inFlight.Wait()
+
+	// The second message is skipped entirely, without another notice.
+	c.messageCallback(testSource, urls[0], nil)
+	c.inFlight.Wait()
+
+	messages := fake.Messages()
+	require.Len(t, messages, 3)
+	require.ElementsMatch(t, []string{rateLimitNotice, "[Test] handled", "[Test] handled"}, messages)
+}
+
+func TestMessageCallbackRateLimitNoticeDoesNotBlock(t *testing.T) {
+	c, fake := newTestClient(t)
+	c.limiter = newRateLimiter(RateLimitConfig{
+		Channel: RateLimit{Limit: 1, Per: time.Hour},
+	})
+	c.Register(&testProvider{host: "example.com"})
+
+	fake.stall = make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		c.messageCallback(testSource, "https://example.com/handled https://example.com/handled?n=2", nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messageCallback blocked on sending the rate limit notice")
+	}
+
+	close(fake.stall)
+	c.inFlight.Wait()
+
+	require.ElementsMatch(t, []string{rateLimitNotice, "[Test] handled"}, fake.Messages())
+}
@@ -0,0 +1,208 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	seabird "github.com/seabird-chat/seabird-go"
+	"github.com/seabird-chat/seabird-go/pb"
+)
+
+// Reply modes which can be selected in ReplyConfig.
+const (
+	// ReplyModeSeparate sends each preview as soon as it's ready.
+	ReplyModeSeparate = "separate"
+
+	// ReplyModeOrdered waits for all the previews for a message and sends
+	// them one at a time, in the order the links were posted.
+	ReplyModeOrdered = "ordered"
+
+	// ReplyModeCombined is like ReplyModeOrdered, but sends all the previews
+	// as a single multi-line message.
+	ReplyModeCombined = "combined"
+
+	// ReplyModeBlocks is like ReplyModeCombined, but sends the previews as a
+	// list block.
+	ReplyModeBlocks = "blocks"
+)
+
+// DefaultReplyDeadline is how long to wait for all the previews for a
+// message in the ordered modes.
+const DefaultReplyDeadline = 10 * time.Second
+
+// ReplyConfig controls how previews for messages with multiple links are
+// sent.
+type ReplyConfig struct {
+	// Mode is one of "separate" (the default), "ordered", "combined" or
+	// "blocks".
+	Mode string
+
+	// Deadline is how long to wait for all the previews for a message before
+	// sending the ones which are ready. It defaults to DefaultReplyDeadline.
+	Deadline time.Duration
+}
+
+func (c ReplyConfig) validate() error {
+	switch c.Mode {
+	case "", ReplyModeSeparate, ReplyModeOrdered, ReplyModeCombined, ReplyModeBlocks:
+	default:
+		return fmt.Errorf("unknown reply mode %q", c.Mode)
+	}
+
+	if c.Deadline < 0 {
+		return errors.New("reply deadline must not be negative")
+	}
+
+	return nil
+}
+
+func (c ReplyConfig) withDefaults() ReplyConfig {
+	if c.Mode == "" {
+		c.Mode = ReplyModeSeparate
+	}
+
+	if c.Deadline == 0 {
+		c.Deadline = DefaultReplyDeadline
+	}
+
+	return c
+}
+
+// previewResults collects the previews for every link in a message.
+type previewResults struct {
+	lock     sync.Mutex
+	previews []*Preview
+	closed   bool
+}
+
+func (r *previewResults) set(i int, p *Preview) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Anything which finishes after the deadline is dropped.
+	if !r.closed {
+		r.previews[i] = p
+	}
+}
+
+// close stops accepting results and returns the ones which are ready.
+func (r *previewResults) close() []*Preview {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+
+	return r.previews
+}
+
+// replyInOrder looks up every URL from a message and, once they're all done
+// or the deadline passes, sends the previews in the order they were posted.
+// Links with their key in spoilers were posted in a spoiler.
+func (c *Client) replyInOrder(source *pb.ChannelSource, rawurls []string, spoilers map[string]bool) {
+	channel := source.GetChannelId()
+	results := &previewResults{previews: make([]*Preview, len(rawurls))}
+
+	var wg sync.WaitGroup
+	for i, raw := range rawurls {
+		wg.Add(1)
+
+		ok := c.dispatch(channel, urlHost(raw), func() {
+			defer wg.Done()
+			results.set(i, c.processURL(source, raw, spoilers[urlKey(raw)]))
+		})
+		if !ok {
+			wg.Done()
+			log.Printf("Dropped lookup of %s in %s: queue is full", raw, channel)
+			c.recordSkipped(source, []string{raw})
+		}
+	}
+
+	c.spawn(func() {
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(c.replies.Deadline):
+			log.Printf("Timed out waiting for previews for message to %s", channel)
+		}
+
+		c.replyPreviews(source, results.close())
+	})
+}
+
+// replyPreviews sends a group of previews according to the reply mode.
+func (c *Client) replyPreviews(source *pb.ChannelSource, previews []*Preview) error {
+	var (
+		lines    []string
+		spoilers []bool
+	)
+	for _, p := range previews {
+		if p == nil || p.sent || p.Err != nil {
+			continue
+		}
+
+		lines = append(lines, c.renderPreview(p))
+		spoilers = append(spoilers, p.spoiler)
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var errs []error
+
+	switch c.replies.Mode {
+	case ReplyModeCombined:
+		// Spoilers can only be hidden with blocks, so they're sent on their
+		// own after everything else.
+		var combined []string
+		for i, line := range lines {
+			if !spoilers[i] {
+				combined = append(combined, line)
+			}
+		}
+
+		if len(combined) > 0 {
+			errs = append(errs, c.Reply(source, strings.Join(combined, "\n")))
+		}
+
+		for i, line := range lines {
+			if spoilers[i] {
+				errs = append(errs, c.replySpoiler(source, line))
+			}
+		}
+
+		return errors.Join(errs...)
+	case ReplyModeBlocks:
+		blocks := make([]*pb.Block, 0, len(lines))
+		for i, line := range lines {
+			block := seabird.NewTextBlock(line)
+			if spoilers[i] {
+				block = seabird.NewSpoilerBlock(block)
+			}
+
+			blocks = append(blocks, block)
+		}
+
+		return c.ReplyBlock(source, strings.Join(lines, "\n"), seabird.NewListBlock(blocks...))
+	}
+
+	for i, line := range lines {
+		if spoilers[i] {
+			errs = append(errs, c.replySpoiler(source, line))
+			continue
+		}
+
+		errs = append(errs, c.Reply(source, line))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,122 @@
+package url
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	seabird "github.com/seabird-chat/seabird-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyModes(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	// The slow link is posted first, so it would normally be sent last.
+	text := strings.Join([]string{
+		server.URL + "/slow",
+		server.URL + "/handled",
+		server.URL + "/handled/",
+		server.URL + "/unhandled",
+	}, " ")
+
+	var tests = []struct {
+		mode     string
+		deadline time.Duration
+		messages []string
+	}{
+		{ReplyModeSeparate, 0, []string{"[Test] handled", "[Test] slow"}},
+		{ReplyModeOrdered, 0, []string{"[Test] slow", "[Test] handled"}},
+		{ReplyModeCombined, 0, []string{"[Test] slow\n[Test] handled"}},
+		{ReplyModeBlocks, 0, []string{"[Test] slow\n[Test] handled"}},
+		{ReplyModeOrdered, 10 * time.Millisecond, []string{"[Test] handled"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			c, fake := newTestClient(t)
+			c.Register(&testProvider{host: host})
+			c.replies = ReplyConfig{Mode: test.mode, Deadline: test.deadline}.withDefaults()
+
+			// Unhandled links fall back to the page title, which doesn't
+			// exist here.
+			c.messageCallback(testSource, text, nil)
+			c.inFlight.Wait()
+
+			require.Equal(t, test.messages, fake.Messages())
+
+			if test.mode == ReplyModeBlocks {
+				list := fake.sent[0].RootBlock.GetList()
+				require.NotNil(t, list)
+				require.Len(t, list.Inner, 2)
+				require.Equal(t, "[Test] slow", list.Inner[0].GetText().Text)
+			}
+		})
+	}
+}
+
+func TestDedupeURLs(t *testing.T) {
+	require.Equal(t, []string{
+		"https://example.com/a",
+		"https://example.com/b",
+		"not a url",
+	}, dedupeURLs([]string{
+		"https://example.com/a",
+		"https://example.com/b",
+		"https://www.example.com/a/",
+		"not a url",
+		"not a url",
+	}))
+}
+
+func TestReplyConfigValidate(t *testing.T) {
+	require.NoError(t, ReplyConfig{}.validate())
+	require.Error(t, ReplyConfig{Mode: "shuffled"}.validate())
+	require.Error(t, ReplyConfig{Deadline: -time.Second}.validate())
+}
+
+func TestReplySpoilers(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	// The first link is in a spoiler, and the second isn't.
+	root := seabird.NewContainerBlock(
+		seabird.NewSpoilerBlock(seabird.NewLinkBlock(server.URL+"/handled")),
+		seabird.NewLinkBlock(server.URL+"/slow"),
+	)
+
+	for _, mode := range []string{ReplyModeSeparate, ReplyModeOrdered, ReplyModeCombined, ReplyModeBlocks} {
+		t.Run(mode, func(t *testing.T) {
+			c, fake := newTestClient(t)
+			c.Register(&testProvider{host: host})
+			c.replies = ReplyConfig{Mode: mode}.withDefaults()
+
+			c.messageCallback(testSource, "", root)
+			c.inFlight.Wait()
+
+			if mode == ReplyModeBlocks {
+				require.Len(t, fake.sent, 1)
+				list := fake.sent[0].RootBlock.GetList()
+				require.NotNil(t, list)
+				require.Len(t, list.Inner, 2)
+				require.Equal(t, "[Test] handled", list.Inner[0].GetSpoiler().GetInner().GetText().GetText())
+				require.Equal(t, "[Test] slow", list.Inner[1].GetText().GetText())
+				return
+			}
+
+			var spoilers, plain []string
+			for _, msg := range fake.sent {
+				if spoiler := msg.RootBlock.GetSpoiler(); spoiler != nil {
+					require.Equal(t, msg.Text, spoiler.GetInner().GetText().GetText())
+					spoilers = append(spoilers, msg.Text)
+				} else {
+					plain = append(plain, msg.Text)
+				}
+			}
+
+			require.Equal(t, []string{"[Test] handled"}, spoilers)
+			require.Equal(t, []string{"[Test] slow"}, plain)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package url
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
+)
+
+// DefaultRepostRetention is how long posted links are remembered if
+// RepostConfig.Retention isn't set.
+const DefaultRepostRetention = 30 * 24 * time.Hour
+
+// RepostConfig controls the "first posted by" notices added to previews of
+// links which were already posted in a channel.
+type RepostConfig struct {
+	// Enabled turns on repost notices in every channel.
+	Enabled bool
+
+	// Channels overrides Enabled for specific channel IDs.
+	Channels map[string]bool
+
+	// Retention is how long posted links are remembered. It defaults to
+	// DefaultRepostRetention.
+	Retention time.Duration
+}
+
+// enabled returns true if reposts should be tracked in the given channel.
+func (c RepostConfig) enabled(channelID string) bool {
+	if enabled, ok := c.Channels[channelID]; ok {
+		return enabled
+	}
+
+	return c.Enabled
+}
+
+// anyEnabled returns true if reposts are tracked in at least one channel.
+func (c RepostConfig) anyEnabled() bool {
+	if c.Enabled {
+		return true
+	}
+
+	for _, enabled := range c.Channels {
+		if enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// repostBucket is the Store bucket used to remember posted links.
+const repostBucket = "reposts"
+
+// repostPruneInterval is how many new links are recorded between sweeps for
+// expired entries.
+const repostPruneInterval = 100
+
+// postedLink records who first posted a link in a channel.
+type postedLink struct {
+	UserID   string
+	UserName string
+	Time     time.Time
+}
+
+// annotation returns the note added to previews of reposted links.
+func (p *postedLink) annotation(now time.Time) string {
+	if p.UserName == "" {
+		return fmt.Sprintf("(first posted %s)", internal.TimeAgo(p.Time, now))
+	}
+
+	return fmt.Sprintf("(first posted by %s %s)", p.UserName, internal.TimeAgo(p.Time, now))
+}
+
+// repostTracker remembers which links were posted in which channels.
+type repostTracker struct {
+	config RepostConfig
+	store  *Store
+
+	// lock makes checking for and recording a link a single operation, so
+	// the same link posted twice at once is only recorded once.
+	lock    sync.Mutex
+	records int
+}
+
+func newRepostTracker(config RepostConfig, store *Store) *repostTracker {
+	if config.Retention == 0 {
+		config.Retention = DefaultRepostRetention
+	}
+
+	t := &repostTracker{
+		config: config,
+		store:  store,
+	}
+	t.prune()
+
+	return t
+}
+
+// record remembers that the link with the given key was posted and returns
+// the earlier post of the same link, if any.
+func (t *repostTracker) record(source *pb.ChannelSource, key string, now time.Time) *postedLink {
+	channelID := source.GetChannelId()
+	if !t.config.enabled(channelID) {
+		return nil
+	}
+
+	storeKey := channelID + " " + key
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var prev postedLink
+
+	ok, err := t.store.get(repostBucket, storeKey, &prev)
+	if err != nil {
+		log.Printf("Failed to look up earlier posts of %s: %s", key, err)
+		return nil
+	}
+
+	if ok && now.Sub(prev.Time) < t.config.Retention {
+		// People reposting their own links don't need to be told about it.
+		if prev.UserID != "" && prev.UserID == source.GetUser().GetId() {
+			return nil
+		}
+
+		return &prev
+	}
+
+	err = t.store.put(repostBucket, storeKey, postedLink{
+		UserID:   source.GetUser().GetId(),
+		UserName: source.GetUser().GetDisplayName(),
+		Time:     now,
+	})
+	if err != nil {
+		log.Printf("Failed to record post of %s: %s", key, err)
+	}
+
+	t.records++
+	if t.records%repostPruneInterval == 0 {
+		t.prune()
+	}
+
+	return nil
+}
+
+// prune removes all links which were posted before the retention period.
+func (t *repostTracker) prune() {
+	cutoff := time.Now().Add(-t.config.Retention)
+
+	err := t.store.deleteWhere(repostBucket, func(key string, data []byte) bool {
+		var link postedLink
+		if err := json.Unmarshal(data, &link); err != nil {
+			return true
+		}
+
+		return link.Time.Before(cutoff)
+	})
+	if err != nil {
+		log.Printf("Failed to prune posted links: %s", err)
+	}
+}
+
+// recordPost remembers that a link was posted and returns the first post of
+// the same link in that channel if this is a repost.
+func (c *Client) recordPost(source *pb.ChannelSource, raw string) *postedLink {
+	if c.reposts == nil {
+		return nil
+	}
+
+	u, err := parseURL(raw)
+	if err != nil {
+		return nil
+	}
+
+	return c.reposts.record(source, cacheKey(u), time.Now())
+}
@@ -0,0 +1,83 @@
+package url
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStore opens a Store in a temporary directory which is closed when
+// the test finishes.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "store.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func TestRepostTracker(t *testing.T) {
+	tracker := newRepostTracker(RepostConfig{
+		Enabled: true,
+		Channels: map[string]bool{
+			"irc://test/#quiet": false,
+		},
+		Retention: 24 * time.Hour,
+	}, newTestStore(t))
+
+	bob := &pb.ChannelSource{
+		ChannelId: testSource.ChannelId,
+		User: &pb.User{
+			Id:          "irc://test/bob",
+			DisplayName: "bob",
+		},
+	}
+
+	now := time.Now()
+
+	require.Nil(t, tracker.record(testSource, "https://example.com", now))
+
+	// Reposting your own link isn't noted.
+	require.Nil(t, tracker.record(testSource, "https://example.com", now.Add(time.Hour)))
+
+	prev := tracker.record(bob, "https://example.com", now.Add(3*time.Hour))
+	require.NotNil(t, prev)
+	require.Equal(t, "(first posted by alice 3 hours ago)", prev.annotation(now.Add(3*time.Hour)))
+
+	// Links are tracked separately in each channel, and not at all in
+	// disabled channels.
+	other := &pb.ChannelSource{ChannelId: "irc://test/#other", User: bob.User}
+	require.Nil(t, tracker.record(other, "https://example.com", now))
+
+	quiet := &pb.ChannelSource{ChannelId: "irc://test/#quiet", User: testSource.User}
+	require.Nil(t, tracker.record(quiet, "https://example.com", now))
+	require.Nil(t, tracker.record(quiet, "https://example.com", now))
+
+	// After the retention period, the next post counts as the first.
+	require.Nil(t, tracker.record(bob, "https://example.com", now.Add(25*time.Hour)))
+	prev = tracker.record(testSource, "https://example.com", now.Add(26*time.Hour))
+	require.NotNil(t, prev)
+	require.Equal(t, "bob", prev.UserName)
+}
+
+func TestRepostAnnotation(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.reposts = newRepostTracker(RepostConfig{Enabled: true}, newTestStore(t))
+
+	require.Nil(t, c.recordPost(testSource, "https://example.com/page/"))
+
+	prev := c.recordPost(&pb.ChannelSource{ChannelId: testSource.ChannelId}, "https://www.example.com/page")
+	require.NotNil(t, prev)
+
+	preview := &Preview{
+		Provider:    "Test",
+		Title:       "title",
+		Annotations: []string{prev.annotation(prev.Time.Add(72 * time.Hour))},
+	}
+	require.Equal(t, "[Test] title (first posted by alice 3 days ago)", renderPreview(preview))
+}
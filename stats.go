@@ -0,0 +1,93 @@
+package url
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LookupStats counts how each lookup for a single provider turned out.
+type LookupStats struct {
+	Handled    int64
+	NotHandled int64
+	Failed     int64
+}
+
+type lookupResult int
+
+const (
+	lookupNotHandled lookupResult = iota
+	lookupHandled
+	lookupFailed
+)
+
+// lookupStatsTracker keeps LookupStats for every provider.
+type lookupStatsTracker struct {
+	lock      sync.Mutex
+	providers map[string]*LookupStats
+}
+
+func (t *lookupStatsTracker) record(provider string, result lookupResult) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.providers == nil {
+		t.providers = make(map[string]*LookupStats)
+	}
+
+	stats, ok := t.providers[provider]
+	if !ok {
+		stats = &LookupStats{}
+		t.providers[provider] = stats
+	}
+
+	switch result {
+	case lookupHandled:
+		stats.Handled++
+	case lookupNotHandled:
+		stats.NotHandled++
+	case lookupFailed:
+		stats.Failed++
+	}
+}
+
+func (t *lookupStatsTracker) snapshot() map[string]LookupStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ret := make(map[string]LookupStats, len(t.providers))
+	for name, stats := range t.providers {
+		ret[name] = *stats
+	}
+
+	return ret
+}
+
+// LookupStats returns a snapshot of the lookup results for every provider
+// which has been called so far.
+func (c *Client) LookupStats() map[string]LookupStats {
+	return c.stats.snapshot()
+}
+
+// formatLookupStats describes the lookup results for every provider on a
+// single line.
+func formatLookupStats(stats map[string]LookupStats) string {
+	if len(stats) == 0 {
+		return "lookups: none"
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		s := stats[name]
+		parts = append(parts, fmt.Sprintf("%s %d handled, %d not handled, %d failed", name, s.Handled, s.NotHandled, s.Failed))
+	}
+
+	return "lookups: " + strings.Join(parts, "; ")
+}
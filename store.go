@@ -0,0 +1,163 @@
+package url
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store is a small embedded database used for any state which needs to
+// survive restarts. Values are stored as JSON in named buckets.
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens (or creates) the store at the given path.
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// get loads the value stored under key into v. It returns false if the key
+// doesn't exist.
+func (s *Store) get(bucket, key string, v interface{}) (bool, error) {
+	var data []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		// Values returned by bolt are only valid for the life of the
+		// transaction, so we need to copy it out.
+		if raw := b.Get([]byte(key)); raw != nil {
+			data = append([]byte(nil), raw...)
+		}
+
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, v)
+}
+
+// put stores v under key.
+func (s *Store) put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), data)
+	})
+}
+
+// delete removes key if it exists.
+func (s *Store) delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// forEach calls fn for every key in the bucket, in key order.
+func (s *Store) forEach(bucket string, fn func(key string, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+// deleteWhere removes every key in the bucket for which fn returns true.
+func (s *Store) deleteWhere(bucket string, fn func(key string, data []byte) bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		var toDelete [][]byte
+
+		err := b.ForEach(func(k, v []byte) error {
+			if fn(string(k), v) {
+				toDelete = append(toDelete, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range toDelete {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// reverseEach calls fn for every key in the bucket starting with prefix, in
+// reverse key order, until fn returns false.
+func (s *Store) reverseEach(bucket, prefix string, fn func(key string, data []byte) (bool, error)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		cur := b.Cursor()
+
+		// Seek finds the first key after the prefix, so the one before it
+		// is the last key with the prefix.
+		var k, v []byte
+		if prefix == "" {
+			k, v = cur.Last()
+		} else if k, _ = cur.Seek([]byte(prefix + "\xff")); k == nil {
+			k, v = cur.Last()
+		} else {
+			k, v = cur.Prev()
+		}
+
+		for ; k != nil && strings.HasPrefix(string(k), prefix); k, v = cur.Prev() {
+			more, err := fn(string(k), v)
+			if err != nil || !more {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultLookupTimeout is how long a single provider gets to look up a URL
+// or handle a message.
+const DefaultLookupTimeout = 10 * time.Second
+
+// lookupTimeouts holds the time budget for each provider.
+type lookupTimeouts struct {
+	fallback  time.Duration
+	providers map[string]time.Duration
+}
+
+func newLookupTimeouts(fallback time.Duration, providers map[string]time.Duration) lookupTimeouts {
+	if fallback == 0 {
+		fallback = DefaultLookupTimeout
+	}
+
+	ret := lookupTimeouts{
+		fallback:  fallback,
+		providers: make(map[string]time.Duration),
+	}
+
+	for name, timeout := range providers {
+		ret.providers[strings.ToLower(name)] = timeout
+	}
+
+	return ret
+}
+
+func validateLookupTimeouts(fallback time.Duration, providers map[string]time.Duration) error {
+	if fallback < 0 {
+		return errors.New("lookup timeout must not be negative")
+	}
+
+	for name, timeout := range providers {
+		if timeout < 0 {
+			return fmt.Errorf("lookup timeout for %s must not be negative", name)
+		}
+	}
+
+	return nil
+}
+
+// get returns the timeout for the named provider.
+func (t lookupTimeouts) get(provider string) time.Duration {
+	if timeout, ok := t.providers[strings.ToLower(provider)]; ok && timeout > 0 {
+		return timeout
+	}
+
+	if t.fallback > 0 {
+		return t.fallback
+	}
+
+	return DefaultLookupTimeout
+}
+
+// lookupContext returns the context for a single call to the named provider.
+// It is cancelled once the provider's timeout passes or the Client shuts
+// down.
+func (c *Client) lookupContext(provider string) (context.Context, context.CancelFunc) {
+	return c.childLookupContext(c.lookupCtx, provider)
+}
+
+// childLookupContext is like lookupContext, but derived from parent, so a
+// lookup made on behalf of another provider can't outlive that provider's
+// own timeout.
+func (c *Client) childLookupContext(parent context.Context, provider string) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	return context.WithTimeout(parent, c.timeouts.get(provider))
+}
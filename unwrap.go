@@ -0,0 +1,99 @@
+package url
+
+import (
+	"net/url"
+	"strings"
+)
+
+// maxUnwrapDepth limits how many redirect wrappers are removed from a single
+// link, in case they're nested.
+const maxUnwrapDepth = 5
+
+// redirectWrapper is a URL which only exists to send people on to another
+// one, like the links in search results or scanned emails. The destination
+// is in a query parameter, so it can be extracted without fetching anything.
+type redirectWrapper struct {
+	// hosts are host patterns, as in ignore lists.
+	hosts []hostPattern
+
+	// path is the path the wrapper is served from. Any path matches if it's
+	// empty.
+	path string
+
+	// params are the query parameters which can hold the destination, in the
+	// order they're checked.
+	params []string
+}
+
+var redirectWrappers = []redirectWrapper{
+	{hosts: mustCompileHostPatterns("google.com"), path: "/url", params: []string{"q", "url"}},
+	{hosts: mustCompileHostPatterns("*.safelinks.protection.outlook.com"), params: []string{"url"}},
+	{hosts: mustCompileHostPatterns("l.facebook.com", "lm.facebook.com"), path: "/l.php", params: []string{"u"}},
+	{hosts: mustCompileHostPatterns("l.instagram.com"), params: []string{"u"}},
+	{hosts: mustCompileHostPatterns("l.messenger.com"), path: "/l.php", params: []string{"u"}},
+	{hosts: mustCompileHostPatterns("slack-redir.net"), path: "/link", params: []string{"url"}},
+	{hosts: mustCompileHostPatterns("youtube.com"), path: "/redirect", params: []string{"q"}},
+	{hosts: mustCompileHostPatterns("duckduckgo.com"), path: "/l", params: []string{"uddg"}},
+	{hosts: mustCompileHostPatterns("steamcommunity.com"), path: "/linkfilter", params: []string{"url", "u"}},
+}
+
+func mustCompileHostPatterns(raw ...string) []hostPattern {
+	ret, err := compileHostPatterns(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return ret
+}
+
+// destination returns the URL the wrapper points at, or nil if u isn't one
+// of its links or doesn't point anywhere useful.
+func (w redirectWrapper) destination(u *url.URL) *url.URL {
+	if !hostMatches(w.hosts, u.Hostname()) {
+		return nil
+	}
+
+	if w.path != "" && strings.TrimRight(u.Path, "/") != w.path {
+		return nil
+	}
+
+	query := u.Query()
+	for _, param := range w.params {
+		target, err := parseRawURL(query.Get(param))
+		if err != nil {
+			continue
+		}
+
+		// Wrappers are only followed to other web pages, so nothing odd
+		// like a javascript: URL ends up being looked up.
+		scheme := strings.ToLower(target.Scheme)
+		if (scheme != "http" && scheme != "https") || target.Host == "" {
+			continue
+		}
+
+		return target
+	}
+
+	return nil
+}
+
+// unwrapURL returns the real destination of a link which goes through one or
+// more redirect wrappers, or u itself if it isn't wrapped.
+func unwrapURL(u *url.URL) *url.URL {
+	for range maxUnwrapDepth {
+		var target *url.URL
+		for _, wrapper := range redirectWrappers {
+			if target = wrapper.destination(u); target != nil {
+				break
+			}
+		}
+
+		if target == nil {
+			break
+		}
+
+		u = target
+	}
+
+	return u
+}
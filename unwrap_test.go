@@ -0,0 +1,64 @@
+package url
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnwrapURL(t *testing.T) {
+	var tests = []struct {
+		raw, expected string
+	}{
+		{"https://www.google.com/url?q=https://github.com/foo/bar&sa=D&ust=123", "https://github.com/foo/bar"},
+		{"https://google.com/url?sa=t&url=https%3A%2F%2Fexample.com%2Fa%3Fb%3D1", "https://example.com/a?b=1"},
+		{"https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fgithub.com%2Ffoo%2Fbar&data=05", "https://github.com/foo/bar"},
+		{"https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2F%3Ffbclid%3Dabc&h=AT0", "https://example.com"},
+		{"https://slack-redir.net/link?url=https%3A%2F%2Fexample.com%2Fpage", "https://example.com/page"},
+		{"https://www.youtube.com/redirect?event=video_description&q=https%3A%2F%2Fexample.com", "https://example.com"},
+		{"https://duckduckgo.com/l/?uddg=https%3A%2F%2Fexample.com%2Fpage&rut=abc", "https://example.com/page"},
+
+		// Nested wrappers are all removed.
+		{"https://www.google.com/url?q=" + url.QueryEscape("https://l.facebook.com/l.php?u="+url.QueryEscape("https://example.com/nested")), "https://example.com/nested"},
+
+		// Anything which doesn't point at another web page is left alone.
+		{"https://www.google.com/url?q=javascript:alert(1)", "https://www.google.com/url?q=javascript:alert(1)"},
+		{"https://www.google.com/url?q=not+a+url", "https://www.google.com/url?q=not+a+url"},
+		{"https://www.google.com/search?q=https://example.com", "https://www.google.com/search?q=https://example.com"},
+		{"https://example.com/url?q=https://github.com", "https://example.com/url?q=https://github.com"},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, canonicalURL(test.raw), test.raw)
+	}
+}
+
+func TestLookupURLUnwrapped(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	var looked string
+	c.callbacks["github.com"] = []registeredCallback{{
+		provider: "Github",
+		callback: func(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
+			looked = u.String()
+			return &Preview{Provider: "Github", Title: "foo/bar"}
+		},
+	}}
+
+	// The real destination is handled by its provider rather than falling
+	// back to the title of the wrapper page.
+	preview := c.lookupURL(testSource, "https://www.google.com/url?q=https://github.com/foo/bar&sa=D")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Github] foo/bar", renderPreview(preview))
+	require.Equal(t, "https://github.com/foo/bar", looked)
+	require.Equal(t, "https://github.com/foo/bar", preview.URL)
+
+	// Ignore lists apply to the destination too.
+	var err error
+	c.ignoreRules, err = compileIgnoreRules([]string{"github.com"})
+	require.NoError(t, err)
+	require.True(t, c.ignoresURL(ChannelPolicy{}, "https://www.google.com/url?q=https://github.com/foo/bar"))
+}
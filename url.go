@@ -1,12 +1,14 @@
 package url
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,85 +17,93 @@ import (
 	"golang.org/x/net/html/atom"
 
 	"github.com/seabird-chat/seabird-go/pb"
-)
 
-// NOTE: This isn't perfect in any sense of the word, but it's pretty close
-// and I don't know if it's worth the time to make it better.
-var (
-	urlRegex     = regexp.MustCompile(`https?://[^ ]+`)
-	newlineRegex = regexp.MustCompile(`\s*\n\s*`)
+	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
+var newlineRegex = regexp.MustCompile(`\s*\n\s*`)
+
+// blockLink is a link found in a block tree.
+type blockLink struct {
+	url string
+
+	// spoiler is set for links inside a spoiler, so their previews can be
+	// hidden too.
+	spoiler bool
+}
+
 // extractURLsFromBlocks recursively walks a block tree and extracts all URLs
-// from LinkBlock nodes.
-func extractURLsFromBlocks(block *pb.Block) []string {
+// from LinkBlock nodes. Links in quotes are skipped, since they were already
+// previewed when they were first posted.
+func extractURLsFromBlocks(block *pb.Block, spoiler bool) []blockLink {
 	if block == nil {
 		return nil
 	}
 
-	var urls []string
+	var urls []blockLink
 
 	switch inner := block.Inner.(type) {
 	case *pb.Block_Link:
 		// Found a link block - extract the URL
 		if inner.Link != nil && inner.Link.Url != "" {
-			urls = append(urls, inner.Link.Url)
+			urls = append(urls, blockLink{url: inner.Link.Url, spoiler: spoiler})
 		}
 		// Also check if the inner block contains more links
 		if inner.Link != nil && inner.Link.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Link.Inner)...)
+			urls = append(urls, extractURLsFromBlocks(inner.Link.Inner, spoiler)...)
 		}
 
 	case *pb.Block_Italics:
 		if inner.Italics != nil && inner.Italics.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Italics.Inner)...)
+			urls = append(urls, extractURLsFromBlocks(inner.Italics.Inner, spoiler)...)
 		}
 
 	case *pb.Block_Bold:
 		if inner.Bold != nil && inner.Bold.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Bold.Inner)...)
+			urls = append(urls, extractURLsFromBlocks(inner.Bold.Inner, spoiler)...)
 		}
 
 	case *pb.Block_Underline:
 		if inner.Underline != nil && inner.Underline.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Underline.Inner)...)
+			urls = append(urls, extractURLsFromBlocks(inner.Underline.Inner, spoiler)...)
 		}
 
 	case *pb.Block_Strikethrough:
 		if inner.Strikethrough != nil && inner.Strikethrough.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Strikethrough.Inner)...)
+			urls = append(urls, extractURLsFromBlocks(inner.Strikethrough.Inner, spoiler)...)
 		}
 
 	case *pb.Block_Spoiler:
 		if inner.Spoiler != nil && inner.Spoiler.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Spoiler.Inner)...)
+			urls = append(urls, extractURLsFromBlocks(inner.Spoiler.Inner, true)...)
 		}
 
 	case *pb.Block_Blockquote:
-		if inner.Blockquote != nil && inner.Blockquote.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Blockquote.Inner)...)
-		}
+		// Nothing in quotes is looked up, since it's usually someone
+		// quoting an earlier message.
 
 	case *pb.Block_Heading:
 		if inner.Heading != nil && inner.Heading.Inner != nil {
-			urls = append(urls, extractURLsFromBlocks(inner.Heading.Inner)...)
+			urls = append(urls, extractURLsFromBlocks(inner.Heading.Inner, spoiler)...)
 		}
 
 	case *pb.Block_Container:
 		if inner.Container != nil {
 			for _, childBlock := range inner.Container.Inner {
-				urls = append(urls, extractURLsFromBlocks(childBlock)...)
+				urls = append(urls, extractURLsFromBlocks(childBlock, spoiler)...)
 			}
 		}
 
 	case *pb.Block_List:
 		if inner.List != nil {
 			for _, childBlock := range inner.List.Inner {
-				urls = append(urls, extractURLsFromBlocks(childBlock)...)
+				urls = append(urls, extractURLsFromBlocks(childBlock, spoiler)...)
 			}
 		}
 
-	// Text blocks don't contain URLs in the block structure
+	// Text blocks don't contain URLs in the block structure, and anything
+	// which looks like a link in code is usually an example, so it's never
+	// previewed.
 	case *pb.Block_Text, *pb.Block_InlineCode, *pb.Block_FencedCode, *pb.Block_Timestamp:
 		// No nested blocks to process
 	}
@@ -101,85 +111,385 @@ func extractURLsFromBlocks(block *pb.Block) []string {
 	return urls
 }
 
+// textFromBlocks returns the text of a block tree, for message callbacks
+// which match more than links. Like extractURLsFromBlocks, code and quotes
+// are skipped.
+func textFromBlocks(block *pb.Block) string {
+	if block == nil {
+		return ""
+	}
+
+	switch inner := block.Inner.(type) {
+	case *pb.Block_Text:
+		return inner.Text.GetText()
+	case *pb.Block_Link:
+		return textFromBlocks(inner.Link.GetInner())
+	case *pb.Block_Italics:
+		return textFromBlocks(inner.Italics.GetInner())
+	case *pb.Block_Bold:
+		return textFromBlocks(inner.Bold.GetInner())
+	case *pb.Block_Underline:
+		return textFromBlocks(inner.Underline.GetInner())
+	case *pb.Block_Strikethrough:
+		return textFromBlocks(inner.Strikethrough.GetInner())
+	case *pb.Block_Spoiler:
+		return textFromBlocks(inner.Spoiler.GetInner())
+	case *pb.Block_Heading:
+		return textFromBlocks(inner.Heading.GetInner())
+	case *pb.Block_Container:
+		return joinBlockText(inner.Container.GetInner())
+	case *pb.Block_List:
+		return joinBlockText(inner.List.GetInner())
+	}
+
+	// Code, quotes and timestamps have no text worth matching.
+	return ""
+}
+
+// joinBlockText joins the text of sibling blocks, separated by spaces so
+// neighbouring blocks can't run together into something they don't say.
+func joinBlockText(blocks []*pb.Block) string {
+	var parts []string
+	for _, child := range blocks {
+		if text := textFromBlocks(child); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
 func (c *Client) messageCallback(source *pb.ChannelSource, text string, rootBlock *pb.Block) {
-	// Run all the message matchers in a goroutine to avoid blocking the main
-	// URL matching. Note that it may be better to call this serially and let
-	// each callback spin up goroutines as needed.
-	go func() {
-		for _, cb := range c.messageCallbacks {
-			cb(c, source, text)
+	channel := source.GetChannelId()
+
+	policy := c.ChannelPolicy(channel)
+	quiet := policy.quiet(time.Now())
+
+	// Run all the message matchers in the background to avoid blocking the
+	// main URL matching. Note that it may be better to call this serially and
+	// let each callback spin up goroutines as needed.
+	if !quiet && len(c.messageCallbacks) > 0 {
+		// Code and quotes are skipped for callbacks just like for links.
+		callbackText := stripCodeSpans(text)
+		if rootBlock != nil {
+			callbackText = textFromBlocks(rootBlock)
 		}
-	}()
 
-	// Use block-based URL extraction if blocks are available, otherwise fall back to regex
-	var rawurls []string
+		c.dispatch(channel, "", func() {
+			for _, cb := range c.messageCallbacks {
+				if !policy.providerEnabled(cb.provider) {
+					continue
+				}
+
+				ctx, cancel := c.lookupContext(cb.provider)
+				cb.callback(ctx, c, source, callbackText)
+				cancel()
+			}
+		})
+	}
+
+	// Use block-based URL extraction if blocks are available, otherwise find
+	// them in the text.
+	var (
+		rawurls  []string
+		spoilers = make(map[string]bool)
+	)
 	if rootBlock != nil {
-		rawurls = extractURLsFromBlocks(rootBlock)
+		for _, link := range extractURLsFromBlocks(rootBlock, false) {
+			rawurls = append(rawurls, link.url)
+			if link.spoiler {
+				spoilers[urlKey(link.url)] = true
+			}
+		}
 	} else {
-		rawurls = urlRegex.FindAllString(text, -1)
+		rawurls = c.extractURLs(text)
 	}
 
-	for _, rawurl := range rawurls {
-		go func(raw string) {
-			u, err := url.ParseRequestURI(raw)
-			if err != nil {
-				return
-			}
+	// Ignored links are skipped before any provider sees them.
+	rawurls = slices.DeleteFunc(rawurls, func(raw string) bool {
+		return c.ignoresURL(policy, raw)
+	})
+
+	// Links are kept as they were posted, but compared in their canonical
+	// form, so the same link posted twice in one message only needs one
+	// preview.
+	rawurls = dedupeURLs(rawurls)
+
+	// Links which aren't looked up are still recorded in the history, just
+	// without a title.
+	if quiet {
+		c.recordSkipped(source, rawurls)
+		return
+	}
+
+	if c.maxURLsPerMessage > 0 && len(rawurls) > c.maxURLsPerMessage {
+		log.Printf("Only looking up %d of %d URLs in message to %s", c.maxURLsPerMessage, len(rawurls), channel)
+		c.recordSkipped(source, rawurls[c.maxURLsPerMessage:])
+		rawurls = rawurls[:c.maxURLsPerMessage]
+	}
+
+	rawurls = c.rateLimitURLs(source, rawurls)
+
+	switch c.replies.Mode {
+	case ReplyModeOrdered, ReplyModeCombined, ReplyModeBlocks:
+		c.replyInOrder(source, rawurls, spoilers)
+		return
+	}
+
+	// By default, each preview is sent as soon as it's ready.
+	for _, raw := range rawurls {
+		ok := c.dispatch(channel, urlHost(raw), func() {
+			c.ReplyPreview(source, c.processURL(source, raw, spoilers[urlKey(raw)]))
+		})
+		if !ok {
+			log.Printf("Dropped lookup of %s in %s: queue is full", raw, channel)
+			c.recordSkipped(source, []string{raw})
+		}
+	}
+}
+
+// processURL looks up a single URL from a message, adding repost notices and
+// recording it in the history. It returns the Preview to send, if any. If
+// the link was in a spoiler, the preview is marked so it's sent in one.
+func (c *Client) processURL(source *pb.ChannelSource, raw string, spoiler bool) *Preview {
+	firstPost := c.recordPost(source, raw)
+
+	preview := c.lookupURL(source, raw)
+	if preview != nil && firstPost != nil {
+		preview.Annotations = append(preview.Annotations, firstPost.annotation(time.Now()))
+	}
+
+	if preview != nil && spoiler {
+		preview.spoiler = true
+	}
+
+	c.recordHistory(source, raw, preview)
+
+	return preview
+}
+
+// dedupeURLs removes any URLs which are the same as an earlier one, keeping
+// the order they were posted in.
+func dedupeURLs(rawurls []string) []string {
+	seen := make(map[string]bool)
+
+	var ret []string
+	for _, raw := range rawurls {
+		key := urlKey(raw)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		ret = append(ret, raw)
+	}
+
+	return ret
+}
 
-			// Strip the last character if it's a slash
-			u.Path = strings.TrimRight(u.Path, "/")
+// urlKey returns the cache key for a raw URL, so different forms of the same
+// link can be matched up. URLs which can't be parsed are used as-is.
+func urlKey(raw string) string {
+	u, err := parseURL(raw)
+	if err != nil {
+		return raw
+	}
 
-			targets := []string{u.Host}
+	return cacheKey(u)
+}
 
-			// If there was a www, we fall back to no www This is not perfect,
-			// but it will fix a number of issues Alternatively, we could
-			// require the linkifiers to register multiple times
-			if strings.HasPrefix(u.Host, "www.") {
-				targets = append(targets, strings.TrimPrefix(u.Host, "www."))
+// urlHost returns the lowercase host of a raw URL, or an empty string if it
+// can't be parsed.
+func urlHost(raw string) string {
+	u, err := parseURL(raw)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// parseURL parses a raw URL found in a message, unwraps it if it goes
+// through a redirect wrapper and converts it to its canonical form.
+func parseURL(raw string) (*url.URL, error) {
+	u, err := parseRawURL(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	u = unwrapURL(u)
+	canonicalizeURL(u)
+
+	return u, nil
+}
+
+// unwrappedURL returns a raw URL from a message with any redirect wrappers
+// removed, but otherwise as it was written. This is what pages are fetched
+// from, since sites don't always treat the canonical form the same, like
+// /foo and /foo/. URLs which can't be parsed are returned as-is.
+func unwrappedURL(raw string) string {
+	u, err := parseRawURL(raw)
+	if err != nil {
+		return raw
+	}
+
+	return unwrapURL(u).String()
+}
+
+// parseRawURL parses a raw URL found in a message exactly as it was written.
+func parseRawURL(raw string) (*url.URL, error) {
+	// ParseRequestURI doesn't expect fragments, so it would leave them in the
+	// path or query.
+	raw, fragment, _ := strings.Cut(raw, "#")
+
+	u, err := url.ParseRequestURI(raw)
+	if err != nil {
+		return nil, err
+	}
+	u.Fragment = fragment
+
+	return u, nil
+}
+
+// lookupURL runs a raw URL through all matching providers, falling back to
+// the generic page title if none of them handle it. It returns nil if there's
+// nothing to display.
+func (c *Client) lookupURL(source *pb.ChannelSource, raw string) *Preview {
+	u, err := parseURL(raw)
+	if err != nil {
+		return nil
+	}
+
+	policy := c.ChannelPolicy(source.GetChannelId())
+
+	// The cache is shared between channels, so channels which disable
+	// providers can only use cached previews from the ones they allow, and
+	// their results aren't cached.
+	key := cacheKey(u)
+	if c.cache != nil {
+		if entry, ok := c.cache.Get(key); ok {
+			if !policy.restricted() {
+				return entry.Preview
 			}
 
-			for _, host := range targets {
-				for _, provider := range c.callbacks[host] {
-					if ok := provider(c, source, u); ok {
-						return
-					}
-				}
+			if entry.Preview != nil && policy.providerEnabled(entry.Preview.Provider) {
+				return entry.Preview
 			}
+		}
+	}
 
-			// If we ran through all the providers and didn't reply, try with
-			// the default link provider.
-			defaultLinkProvider(c, source, raw)
-		}(rawurl)
+	preview := c.dispatchURL(c.lookupCtx, source, u, unwrappedURL(raw), policy)
+	if !policy.restricted() {
+		c.cachePreview(key, preview)
 	}
+
+	return preview
 }
 
-// NOTE: This nasty work is done so we ignore invalid ssl certs. We know what
-// we're doing, I promise. Famous last words.
-//
-//nolint:gosec
-var client = &http.Client{
-	Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	},
-	Timeout: 5 * time.Second,
+// dispatchURL looks up a parsed URL without going through the cache.
+// Providers are given u, which should be canonical, while the page title is
+// fetched from raw. Each provider's context is derived from parent.
+func (c *Client) dispatchURL(parent context.Context, source *pb.ChannelSource, u *url.URL, raw string, policy ChannelPolicy) *Preview {
+	targets := []string{u.Host}
+
+	// If there was a www, we fall back to no www This is not perfect,
+	// but it will fix a number of issues Alternatively, we could
+	// require the linkifiers to register multiple times
+	if strings.HasPrefix(u.Host, "www.") {
+		targets = append(targets, strings.TrimPrefix(u.Host, "www."))
+	}
+
+	var failed bool
+
+	for _, host := range targets {
+		for _, cb := range c.callbacks[host] {
+			if !policy.providerEnabled(cb.provider) {
+				continue
+			}
+
+			ctx, cancel := c.childLookupContext(parent, cb.provider)
+			preview := cb.callback(ctx, c, source, u)
+			cancel()
+
+			switch {
+			case preview == nil:
+				c.stats.record(cb.provider, lookupNotHandled)
+				continue
+			case preview.Err != nil:
+				c.stats.record(cb.provider, lookupFailed)
+				log.Printf("%s failed to look up %s: %s", cb.provider, raw, preview.Err)
+				failed = true
+				continue
+			}
+
+			c.stats.record(cb.provider, lookupHandled)
+
+			if preview.URL == "" {
+				preview.URL = u.String()
+			}
+
+			return preview
+		}
+	}
+
+	// If a provider recognized the URL but couldn't look it up, the page
+	// title is often just a less useful version of the same thing, so we
+	// may want to stay quiet instead.
+	if failed && c.quietOnError {
+		return nil
+	}
+
+	if !policy.providerEnabled(titleProviderName) {
+		return nil
+	}
+
+	// If we ran through all the providers and didn't reply, try with the
+	// default link provider.
+	ctx, cancel := c.childLookupContext(parent, titleProviderName)
+	defer cancel()
+
+	preview := c.defaultLinkProvider(ctx, raw)
+	switch {
+	case preview == nil:
+		c.stats.record(titleProviderName, lookupNotHandled)
+	case preview.Err != nil:
+		c.stats.record(titleProviderName, lookupFailed)
+		log.Printf("Failed to get title for %s: %s", raw, preview.Err)
+	default:
+		c.stats.record(titleProviderName, lookupHandled)
+	}
+
+	return preview
 }
 
-func defaultLinkProvider(c *Client, source *pb.ChannelSource, url string) bool {
-	resp, err := client.Get(url)
+// titleProviderName is used for previews generated by the generic page title
+// fallback.
+const titleProviderName = "Title"
+
+func (c *Client) defaultLinkProvider(ctx context.Context, url string) *Preview {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return false
+		return errorPreview(titleProviderName, err)
+	}
+
+	resp, problem, err := c.scrape(req)
+	if errors.Is(err, internal.ErrBlockedAddress) {
+		log.Printf("Not fetching title for %s: %s", url, err)
+		return nil
+	}
+	if err != nil {
+		return errorPreview(titleProviderName, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return false
+		return nil
 	}
 
 	// We search the first 1K and if a title isn't in there, we deal with it
 	z, err := html.Parse(io.LimitReader(resp.Body, 1024*1024))
 	if err != nil {
-		log.Printf("Failed to grab URL: %s", err)
-		return false
+		return errorPreview(titleProviderName, err)
 	}
 
 	// Scrape the tree for the first title node we find
@@ -187,11 +497,19 @@ func defaultLinkProvider(c *Client, source *pb.ChannelSource, url string) bool {
 
 	// If we got a result, pull the text from it
 	if ok {
-		title := newlineRegex.ReplaceAllLiteralString(scrape.Text(n), " ")
-		c.Replyf(source, "Title: %s", title)
-		return true
+		preview := &Preview{
+			Provider: titleProviderName,
+			Title:    newlineRegex.ReplaceAllLiteralString(scrape.Text(n), " "),
+			URL:      url,
+		}
+
+		if problem != "" {
+			preview.Annotations = append(preview.Annotations, certificateAnnotation(problem))
+		}
+
+		return preview
 	}
 
 	// URL not handled
-	return false
+	return nil
 }
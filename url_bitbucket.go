@@ -1,7 +1,9 @@
 package url
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -51,23 +53,54 @@ var (
 	bitbucketIssueRegex = regexp.MustCompile(`^/([^/]+)/([^/]+)/issue/([^/]+)/[^/]+$`)
 	bitbucketPullRegex  = regexp.MustCompile(`^/([^/]+)/([^/]+)/pull-request/([^/]+)/.*$`)
 
-	bitbucketPrefix = "[Bitbucket]"
+	bitbucketName = "Bitbucket"
 
-	userURL             = "https://bitbucket.org/api/2.0/users/%s"
-	repoURL             = "https://bitbucket.org/api/2.0/repositories/%s/%s"
-	repoIssuesURL       = "https://bitbucket.org/api/1.0/repositories/%s/%s/issues/%s"
-	repoPullRequestsURL = "https://bitbucket.org/api/2.0/repositories/%s/%s/pullrequests/%s"
+	userURL             = "%s/2.0/users/%s"
+	repoURL             = "%s/2.0/repositories/%s/%s"
+	repoIssuesURL       = "%s/1.0/repositories/%s/%s/issues/%s"
+	repoPullRequestsURL = "%s/2.0/repositories/%s/%s/pullrequests/%s"
 )
 
-type BitbucketProvider struct{}
+const defaultBitbucketBaseURL = "https://bitbucket.org/api"
 
-func NewBitbucketProvider() *BitbucketProvider {
-	return &BitbucketProvider{}
+// BitbucketOptions configures a BitbucketProvider.
+type BitbucketOptions struct {
+	// HTTPClient is used for all API requests. Defaults to a
+	// client with the default timeout and limits.
+	HTTPClient *http.Client
+
+	// BaseURL is the root of the Bitbucket API. Defaults to
+	// https://bitbucket.org/api.
+	BaseURL string
+}
+
+type BitbucketProvider struct {
+	http    *http.Client
+	baseURL string
 }
 
-func (p *BitbucketProvider) GetCallbacks() map[string]URLCallback {
-	return map[string]URLCallback{
-		"bitbucket.org": bitbucketCallback,
+func NewBitbucketProvider(opts BitbucketOptions) *BitbucketProvider {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = internal.NewDefaultHTTPClient()
+	}
+
+	if opts.BaseURL == "" {
+		opts.BaseURL = defaultBitbucketBaseURL
+	}
+
+	return &BitbucketProvider{
+		http:    opts.HTTPClient,
+		baseURL: strings.TrimSuffix(opts.BaseURL, "/"),
+	}
+}
+
+func (p *BitbucketProvider) Name() string {
+	return bitbucketName
+}
+
+func (p *BitbucketProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
+		"bitbucket.org": p.bitbucketCallback,
 	}
 }
 
@@ -75,52 +108,57 @@ func (p *BitbucketProvider) GetMessageCallback() MessageCallback {
 	return nil
 }
 
-func bitbucketCallback(c *Client, source *pb.ChannelSource, url *url.URL) bool {
+func (p *BitbucketProvider) bitbucketCallback(ctx context.Context, c *Client, source *pb.ChannelSource, url *url.URL) *Preview {
 	//nolint:gocritic
 	if bitbucketUserRegex.MatchString(url.Path) {
-		return bitbucketGetUser(c, source, url)
+		return p.getUser(ctx, url)
 	} else if bitbucketRepoRegex.MatchString(url.Path) {
-		return bitbucketGetRepo(c, source, url)
+		return p.getRepo(ctx, url)
 	} else if bitbucketIssueRegex.MatchString(url.Path) {
-		return bitbucketGetIssue(c, source, url)
+		return p.getIssue(ctx, url)
 	} else if bitbucketPullRegex.MatchString(url.Path) {
-		return bitbucketGetPull(c, source, url)
+		return p.getPull(ctx, url)
 	}
 
-	return false
+	return nil
 }
 
-func bitbucketGetUser(c *Client, source *pb.ChannelSource, url *url.URL) bool {
+func (p *BitbucketProvider) getUser(ctx context.Context, url *url.URL) *Preview {
 	matches := bitbucketUserRegex.FindStringSubmatch(url.Path)
 	if len(matches) != 2 {
-		return false
+		return nil
 	}
 
 	user := matches[1]
 
 	bu := &bitbucketUser{}
-	if err := internal.GetJSON(fmt.Sprintf(userURL, user), bu); err != nil {
-		return false
+	if err := internal.GetJSON(ctx, p.http, fmt.Sprintf(userURL, p.baseURL, user), bu); err != nil {
+		return errorPreview(bitbucketName, err)
 	}
 
 	// Jay Vana (@jsvana)
-	c.Replyf(source, "%s %s (@%s)", bitbucketPrefix, bu.DisplayName, bu.Username)
-
-	return true
+	return &Preview{
+		Provider: bitbucketName,
+		Title:    fmt.Sprintf("%s (@%s)", bu.DisplayName, bu.Username),
+		Fields: []PreviewField{
+			{Name: "username", Value: bu.Username},
+			{Name: "display_name", Value: bu.DisplayName},
+		},
+	}
 }
 
-func bitbucketGetRepo(c *Client, source *pb.ChannelSource, url *url.URL) bool {
+func (p *BitbucketProvider) getRepo(ctx context.Context, url *url.URL) *Preview {
 	matches := bitbucketRepoRegex.FindStringSubmatch(url.Path)
 	if len(matches) != 3 {
-		return false
+		return nil
 	}
 
 	user := matches[1]
 	repo := matches[2]
 
 	br := &bitbucketRepo{}
-	if err := internal.GetJSON(fmt.Sprintf(repoURL, user, repo), br); err != nil {
-		return false
+	if err := internal.GetJSON(ctx, p.http, fmt.Sprintf(repoURL, p.baseURL, user, repo), br); err != nil {
+		return errorPreview(bitbucketName, err)
 	}
 
 	// chriskempson/base16-iterm2 [Shell] Last pushed to 15 Nov 2014 - Base16 for iTerm2
@@ -131,20 +169,27 @@ func bitbucketGetRepo(c *Client, source *pb.ChannelSource, url *url.URL) bool {
 
 	tm, err := time.Parse(time.RFC3339, br.UpdatedOn)
 	if err != nil {
-		return false
+		return errorPreview(bitbucketName, err)
 	}
 
 	out += " Last pushed to " + tm.Format("2 Jan 2006")
 
-	c.Replyf(source, "%s %s", bitbucketPrefix, out)
-
-	return true
+	return &Preview{
+		Provider: bitbucketName,
+		Title:    out,
+		Fields: []PreviewField{
+			{Name: "repo", Value: br.FullName},
+			{Name: "language", Value: br.Language},
+			{Name: "description", Value: br.Description},
+			{Name: "updated", Value: tm.Format(time.RFC3339)},
+		},
+	}
 }
 
-func bitbucketGetIssue(c *Client, source *pb.ChannelSource, url *url.URL) bool {
+func (p *BitbucketProvider) getIssue(ctx context.Context, url *url.URL) *Preview {
 	matches := bitbucketIssueRegex.FindStringSubmatch(url.Path)
 	if len(matches) != 4 {
-		return false
+		return nil
 	}
 
 	user := matches[1]
@@ -152,8 +197,8 @@ func bitbucketGetIssue(c *Client, source *pb.ChannelSource, url *url.URL) bool {
 	issueNum := matches[3]
 
 	bi := &bitbucketIssue{}
-	if err := internal.GetJSON(fmt.Sprintf(repoIssuesURL, user, repo, issueNum), bi); err != nil {
-		return false
+	if err := internal.GetJSON(ctx, p.http, fmt.Sprintf(repoIssuesURL, p.baseURL, user, repo, issueNum), bi); err != nil {
+		return errorPreview(bitbucketName, err)
 	}
 
 	// If there isn't a user, we can probably assume they're anonymous
@@ -175,20 +220,28 @@ func bitbucketGetIssue(c *Client, source *pb.ChannelSource, url *url.URL) bool {
 
 	tm, err := time.Parse("2006-01-02T15:04:05.000", bi.CreatedOn)
 	if err != nil {
-		return false
+		return errorPreview(bitbucketName, err)
 	}
 
 	out += " [created " + tm.Format("2 Jan 2006") + "]"
 
-	c.Replyf(source, "%s %s", bitbucketPrefix, out)
-
-	return true
+	return &Preview{
+		Provider: bitbucketName,
+		Title:    out,
+		Fields: []PreviewField{
+			{Name: "repo", Value: user + "/" + repo},
+			{Name: "number", Value: issueNum},
+			{Name: "state", Value: bi.Status},
+			{Name: "author", Value: bi.ReportedBy.Username},
+			{Name: "created", Value: tm.Format(time.RFC3339)},
+		},
+	}
 }
 
-func bitbucketGetPull(c *Client, source *pb.ChannelSource, url *url.URL) bool {
+func (p *BitbucketProvider) getPull(ctx context.Context, url *url.URL) *Preview {
 	matches := bitbucketPullRegex.FindStringSubmatch(url.Path)
 	if len(matches) != 4 {
-		return false
+		return nil
 	}
 
 	user := matches[1]
@@ -196,8 +249,8 @@ func bitbucketGetPull(c *Client, source *pb.ChannelSource, url *url.URL) bool {
 	pullNum := matches[3]
 
 	bpr := &bitbucketPullRequest{}
-	if err := internal.GetJSON(fmt.Sprintf(repoPullRequestsURL, user, repo, pullNum), bpr); err != nil {
-		return false
+	if err := internal.GetJSON(ctx, p.http, fmt.Sprintf(repoPullRequestsURL, p.baseURL, user, repo, pullNum), bpr); err != nil {
+		return errorPreview(bitbucketName, err)
 	}
 
 	// Pull request #59 on belak/go-seabird created by jsvana [open] - Add stuff to links [created 4 Jan 2015]
@@ -208,12 +261,20 @@ func bitbucketGetPull(c *Client, source *pb.ChannelSource, url *url.URL) bool {
 
 	tm, err := time.Parse("2006-01-02T15:04:05.000000-07:00", bpr.CreatedOn)
 	if err != nil {
-		return false
+		return errorPreview(bitbucketName, err)
 	}
 
 	out += " [created " + tm.Format("2 Jan 2006") + "]"
 
-	c.Replyf(source, "%s %s", bitbucketPrefix, out)
-
-	return true
+	return &Preview{
+		Provider: bitbucketName,
+		Title:    out,
+		Fields: []PreviewField{
+			{Name: "repo", Value: user + "/" + repo},
+			{Name: "number", Value: pullNum},
+			{Name: "state", Value: strings.ToLower(bpr.State)},
+			{Name: "author", Value: bpr.Author.Username},
+			{Name: "created", Value: tm.Format(time.RFC3339)},
+		},
+	}
 }
@@ -0,0 +1,57 @@
+package url
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBitbucketProvider(t *testing.T) {
+	server := newFixtureServer(t, "bitbucket", map[string]string{
+		"/2.0/users/broken":                                  "500:",
+		"/2.0/users/jsvana":                                  "user.json",
+		"/2.0/repositories/chriskempson/base16-iterm2":       "repo.json",
+		"/1.0/repositories/belak/go-seabird/issues/51":       "issue.json",
+		"/2.0/repositories/belak/go-seabird/pullrequests/59": "pull.json",
+	})
+
+	p := NewBitbucketProvider(BitbucketOptions{
+		HTTPClient: http.DefaultClient,
+		BaseURL:    server.URL,
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "user",
+			url:   "https://bitbucket.org/jsvana",
+			reply: "[Bitbucket] Jay Vana (@jsvana)",
+		},
+		{
+			name:  "repo",
+			url:   "https://bitbucket.org/chriskempson/base16-iterm2",
+			reply: "[Bitbucket] chriskempson/base16-iterm2 [shell] Last pushed to 15 Nov 2014",
+		},
+		{
+			name:  "issue",
+			url:   "https://bitbucket.org/belak/go-seabird/issue/51/expand-issues-plugin",
+			reply: "[Bitbucket] Issue #51 on belak/go-seabird [open] [major - enhancement] by jsvana - Expand issues plugin with more of Bitbucket [created 3 Jan 2015]",
+		},
+		{
+			name:  "pull",
+			url:   "https://bitbucket.org/belak/go-seabird/pull-request/59/add-stuff-to-links",
+			reply: "[Bitbucket] Pull request #59 on belak/go-seabird created by jsvana [open] - Add stuff to links [created 4 Jan 2015]",
+		},
+		{
+			name: "unknown path",
+			url:  "https://bitbucket.org/belak/go-seabird/src/master/README.md",
+		},
+		{
+			name: "missing user",
+			url:  "https://bitbucket.org/nobody",
+		},
+		{
+			name:   "api error",
+			url:    "https://bitbucket.org/broken",
+			failed: true,
+		},
+	})
+}
@@ -3,10 +3,12 @@ package url
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/google/go-github/github"
 	"github.com/seabird-chat/seabird-go/pb"
@@ -15,25 +17,63 @@ import (
 	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
+// GithubOptions configures a GithubProvider.
+type GithubOptions struct {
+	// Token is a GitHub API token. If it is empty, requests are made
+	// unauthenticated, which GitHub limits to a much lower rate.
+	Token string
+
+	// HTTPClient is used for all API requests. Defaults to a
+	// client with the default timeout and limits.
+	HTTPClient *http.Client
+
+	// BaseURL is the root of the GitHub REST API. Defaults to
+	// https://api.github.com/.
+	BaseURL string
+}
+
 type GithubProvider struct {
 	api *github.Client
 }
 
-func NewGithubProvider(token string) *GithubProvider {
-	// Create an oauth2 client
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(context.TODO(), ts)
+func NewGithubProvider(opts GithubOptions) (*GithubProvider, error) {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = internal.NewDefaultHTTPClient()
+	}
 
-	// Create a github client from the oauth2 client
-	return &GithubProvider{
-		api: github.NewClient(tc),
+	httpClient := opts.HTTPClient
+	if opts.Token != "" {
+		// Create an oauth2 client which wraps our shared http client
+		ts := oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: opts.Token},
+		)
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, opts.HTTPClient)
+		httpClient = oauth2.NewClient(ctx, ts)
+	}
+
+	// Create a github client from the (possibly oauth2) client
+	api := github.NewClient(httpClient)
+
+	if opts.BaseURL != "" {
+		baseURL, err := url.Parse(strings.TrimSuffix(opts.BaseURL, "/") + "/")
+		if err != nil {
+			return nil, err
+		}
+
+		api.BaseURL = baseURL
 	}
+
+	return &GithubProvider{
+		api: api,
+	}, nil
+}
+
+func (p *GithubProvider) Name() string {
+	return githubName
 }
 
-func (p *GithubProvider) GetCallbacks() map[string]URLCallback {
-	return map[string]URLCallback{
+func (p *GithubProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
 		"github.com":      p.githubCallback,
 		"gist.github.com": p.gistCallback,
 	}
@@ -50,9 +90,20 @@ var (
 	githubPullRegex  = regexp.MustCompile(`^/([^/]+)/([^/]+)/pull/([^/]+)$`)
 	githubGistRegex  = regexp.MustCompile(`^/([^/]+)/([^/]+)$`)
 
-	githubPrefix = "[Github]"
+	githubName = "Github"
 )
 
+// githubErrorPreview converts an error from the GitHub API to a Preview. As
+// with other providers, a 404 means the URL isn't handled.
+func githubErrorPreview(err error) *Preview {
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return errorPreview(githubName, err)
+}
+
 func parseUserRepoNum(matches []string) (string, string, int, error) {
 	if len(matches) != 4 {
 		return "", "", 0, errors.New("Incorrect number of matches")
@@ -66,27 +117,27 @@ func parseUserRepoNum(matches []string) (string, string, int, error) {
 	return matches[1], matches[2], int(retInt), nil
 }
 
-func (p *GithubProvider) githubCallback(c *Client, source *pb.ChannelSource, u *url.URL) bool {
+func (p *GithubProvider) githubCallback(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
 	//nolint:gocritic
 	if githubUserRegex.MatchString(u.Path) {
-		return p.getUser(c, source, u.Path)
+		return p.getUser(ctx, u.Path)
 	} else if githubRepoRegex.MatchString(u.Path) {
-		return p.getRepo(c, source, u.Path)
+		return p.getRepo(ctx, u.Path)
 	} else if githubIssueRegex.MatchString(u.Path) {
-		return p.getIssue(c, source, u.Path)
+		return p.getIssue(ctx, u.Path)
 	} else if githubPullRegex.MatchString(u.Path) {
-		return p.getPull(c, source, u.Path)
+		return p.getPull(ctx, u.Path)
 	}
 
-	return false
+	return nil
 }
 
-func (p *GithubProvider) gistCallback(c *Client, source *pb.ChannelSource, u *url.URL) bool {
+func (p *GithubProvider) gistCallback(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
 	if githubGistRegex.MatchString(u.Path) {
-		return p.getGist(c, source, u.Path)
+		return p.getGist(ctx, u.Path)
 	}
 
-	return false
+	return nil
 }
 
 // Jay Vana (@jsvana) at Facebook - Bio bio bio
@@ -101,33 +152,36 @@ var userTemplate = internal.TemplateMustCompile("githubUser", `
 {{- with .user.Bio }} - {{ . }}{{ end -}}
 `)
 
-func (p *GithubProvider) getUser(c *Client, source *pb.ChannelSource, url string) bool {
+func (p *GithubProvider) getUser(ctx context.Context, url string) *Preview {
 	matches := githubUserRegex.FindStringSubmatch(url)
 	if len(matches) != 2 {
-		return false
+		return nil
 	}
 
-	user, _, err := p.api.Users.Get(context.TODO(), matches[1])
+	user, _, err := p.api.Users.Get(ctx, matches[1])
 	if err != nil {
-		log.Printf("Failed to get user from github: %s", err)
-		return false
+		return githubErrorPreview(fmt.Errorf("failed to get user: %w", err))
 	}
 
-	ret, err := internal.RenderTemplate(
-		userTemplate, githubPrefix,
+	ret, err := internal.ExecuteTemplate(
+		userTemplate,
 		map[string]interface{}{
 			"user": user,
 		},
 	)
 	if err != nil {
-		log.Printf("Failed to render template: %s", err)
-		return false
+		return errorPreview(githubName, err)
 	}
 
-	c.Reply(source, ret)
-
-	return true
-
+	return &Preview{
+		Provider: githubName,
+		Title:    ret,
+		Fields: []PreviewField{
+			{Name: "login", Value: user.GetLogin()},
+			{Name: "name", Value: user.GetName()},
+			{Name: "company", Value: user.GetCompany()},
+		},
+	}
 }
 
 // jsvana/alfred [PHP] (forked from belak/alfred) Last pushed to 2 Jan 2015 - Description, 1 fork, 2 open issues, 4 stars
@@ -142,42 +196,47 @@ var repoTemplate = internal.TemplateMustCompile("githubRepo", `
 {{- with .repo.StargazersCount }}, {{ prettifySuffix . }} {{ pluralizeWord . "star" }}{{ end }}
 `)
 
-func (p *GithubProvider) getRepo(c *Client, source *pb.ChannelSource, url string) bool {
+func (p *GithubProvider) getRepo(ctx context.Context, url string) *Preview {
 	matches := githubRepoRegex.FindStringSubmatch(url)
 	if len(matches) != 3 {
-		return false
+		return nil
 	}
 
 	user := matches[1]
 	repoName := matches[2]
-	repo, _, err := p.api.Repositories.Get(context.TODO(), user, repoName)
+	repo, _, err := p.api.Repositories.Get(ctx, user, repoName)
 
 	if err != nil {
-		log.Printf("Failed to get repo from github: %s", err)
-		return false
+		return githubErrorPreview(fmt.Errorf("failed to get repo: %w", err))
 	}
 
 	// If the repo doesn't have a name, we get outta there
 	if repo.FullName == nil || *repo.FullName == "" {
-		log.Println("Invalid repo returned from github")
-		return false
+		return errorPreview(githubName, errors.New("invalid repo returned from github"))
 	}
 
-	ret, err := internal.RenderTemplate(
-		repoTemplate, githubPrefix,
+	ret, err := internal.ExecuteTemplate(
+		repoTemplate,
 		map[string]interface{}{
 			"repo": repo,
 		},
 	)
 	if err != nil {
-		log.Printf("Failed to render template: %s", err)
-		return false
+		return errorPreview(githubName, err)
 	}
 
-	c.Reply(source, ret)
-
-	return true
-
+	return &Preview{
+		Provider: githubName,
+		Title:    ret,
+		Fields: []PreviewField{
+			{Name: "repo", Value: repo.GetFullName()},
+			{Name: "language", Value: repo.GetLanguage()},
+			{Name: "description", Value: repo.GetDescription()},
+			{Name: "stars", Value: strconv.Itoa(repo.GetStargazersCount())},
+			{Name: "forks", Value: strconv.Itoa(repo.GetForksCount())},
+			{Name: "open_issues", Value: strconv.Itoa(repo.GetOpenIssuesCount())},
+		},
+	}
 }
 
 // Issue #42 on belak/go-seabird [open] (assigned to jsvana) - Issue title [created 2 Jan 2015]
@@ -188,23 +247,21 @@ Issue #{{ .issue.Number }} on {{ .user }}/{{ .repo }} [{{ .issue.State }}]
 {{- with .issue.CreatedAt }} [created {{ . | dateFormat "2 Jan 2006" }}]{{ end }}
 `)
 
-func (p *GithubProvider) getIssue(c *Client, source *pb.ChannelSource, url string) bool {
+func (p *GithubProvider) getIssue(ctx context.Context, url string) *Preview {
 	matches := githubIssueRegex.FindStringSubmatch(url)
 
 	user, repo, issueNum, err := parseUserRepoNum(matches)
 	if err != nil {
-		log.Printf("Failed to parse URL: %s", err)
-		return false
+		return nil
 	}
 
-	issue, _, err := p.api.Issues.Get(context.TODO(), user, repo, issueNum)
+	issue, _, err := p.api.Issues.Get(ctx, user, repo, issueNum)
 	if err != nil {
-		log.Printf("Failed to get issue from github: %s", err)
-		return false
+		return githubErrorPreview(fmt.Errorf("failed to get issue: %w", err))
 	}
 
-	ret, err := internal.RenderTemplate(
-		issueTemplate, githubPrefix,
+	ret, err := internal.ExecuteTemplate(
+		issueTemplate,
 		map[string]interface{}{
 			"issue": issue,
 			"user":  user,
@@ -212,14 +269,20 @@ func (p *GithubProvider) getIssue(c *Client, source *pb.ChannelSource, url strin
 		},
 	)
 	if err != nil {
-		log.Printf("Failed to render template: %s", err)
-		return false
+		return errorPreview(githubName, err)
 	}
 
-	c.Reply(source, ret)
-
-	return true
-
+	return &Preview{
+		Provider: githubName,
+		Title:    ret,
+		Fields: []PreviewField{
+			{Name: "repo", Value: user + "/" + repo},
+			{Name: "number", Value: strconv.Itoa(issue.GetNumber())},
+			{Name: "state", Value: issue.GetState()},
+			{Name: "title", Value: issue.GetTitle()},
+			{Name: "assignee", Value: issue.GetAssignee().GetLogin()},
+		},
+	}
 }
 
 // Pull request #59 on belak/go-seabird [open] - Title title title [created 4 Jan 2015], 1 commit, 4 comments, 2 changed files
@@ -233,23 +296,21 @@ Pull request #{{ .pull.Number }} on {{ .user }}/{{ .repo }} [{{ .pull.State }}]
 {{- with .pull.ChangedFiles }}, {{ pluralize . "changed file" }}{{ end }}
 `)
 
-func (p *GithubProvider) getPull(c *Client, source *pb.ChannelSource, url string) bool {
+func (p *GithubProvider) getPull(ctx context.Context, url string) *Preview {
 	matches := githubPullRegex.FindStringSubmatch(url)
 
 	user, repo, pullNum, err := parseUserRepoNum(matches)
 	if err != nil {
-		log.Printf("Failed to parse URL: %s", err)
-		return false
+		return nil
 	}
 
-	pull, _, err := p.api.PullRequests.Get(context.TODO(), user, repo, pullNum)
+	pull, _, err := p.api.PullRequests.Get(ctx, user, repo, pullNum)
 	if err != nil {
-		log.Printf("Failed to get pr from github: %s", err)
-		return false
+		return githubErrorPreview(fmt.Errorf("failed to get pull request: %w", err))
 	}
 
-	ret, err := internal.RenderTemplate(
-		prTemplate, githubPrefix,
+	ret, err := internal.ExecuteTemplate(
+		prTemplate,
 		map[string]interface{}{
 			"user": user,
 			"repo": repo,
@@ -257,14 +318,20 @@ func (p *GithubProvider) getPull(c *Client, source *pb.ChannelSource, url string
 		},
 	)
 	if err != nil {
-		log.Printf("Failed to render template: %s", err)
-		return false
+		return errorPreview(githubName, err)
 	}
 
-	c.Reply(source, ret)
-
-	return true
-
+	return &Preview{
+		Provider: githubName,
+		Title:    ret,
+		Fields: []PreviewField{
+			{Name: "repo", Value: user + "/" + repo},
+			{Name: "number", Value: strconv.Itoa(pull.GetNumber())},
+			{Name: "state", Value: pull.GetState()},
+			{Name: "title", Value: pull.GetTitle()},
+			{Name: "author", Value: pull.GetUser().GetLogin()},
+		},
+	}
 }
 
 // Created 3 Jan 2015 by belak - Description description, 1 file, 3 comments
@@ -275,32 +342,36 @@ Created {{ .gist.CreatedAt | dateFormat "2 Jan 2006" }}
 {{- with .gist.Comments }}, {{ pluralize . "comment" }}{{ end }}
 `)
 
-func (p *GithubProvider) getGist(c *Client, source *pb.ChannelSource, url string) bool {
+func (p *GithubProvider) getGist(ctx context.Context, url string) *Preview {
 	matches := githubGistRegex.FindStringSubmatch(url)
 	if len(matches) != 3 {
-		return false
+		return nil
 	}
 
 	id := matches[2]
 
-	gist, _, err := p.api.Gists.Get(context.TODO(), id)
+	gist, _, err := p.api.Gists.Get(ctx, id)
 	if err != nil {
-		log.Printf("Failed to get gist: %s", err)
-		return false
+		return githubErrorPreview(fmt.Errorf("failed to get gist: %w", err))
 	}
 
-	ret, err := internal.RenderTemplate(
-		gistTemplate, githubPrefix,
+	ret, err := internal.ExecuteTemplate(
+		gistTemplate,
 		map[string]interface{}{
 			"gist": gist,
 		},
 	)
 	if err != nil {
-		log.Printf("Failed to render template: %s", err)
-		return false
+		return errorPreview(githubName, err)
 	}
 
-	c.Reply(source, ret)
-
-	return true
+	return &Preview{
+		Provider: githubName,
+		Title:    ret,
+		Fields: []PreviewField{
+			{Name: "id", Value: gist.GetID()},
+			{Name: "owner", Value: gist.GetOwner().GetLogin()},
+			{Name: "description", Value: gist.GetDescription()},
+		},
+	}
 }
@@ -0,0 +1,113 @@
+package url
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGithubProvider(t *testing.T) {
+	server := newFixtureServer(t, "github", map[string]string{
+		"/users/jsvana":                     "user.json",
+		"/repos/jsvana/alfred":              "repo.json",
+		"/repos/belak/go-seabird/issues/42": "issue.json",
+		"/repos/belak/go-seabird/pulls/59":  "pull.json",
+		"/repos/belak/broken":               "500:",
+		"/gists/aa5a315d61ae9438b18d":       "gist.json",
+	})
+
+	p, err := NewGithubProvider(GithubOptions{
+		Token:      "test-token",
+		HTTPClient: http.DefaultClient,
+		BaseURL:    server.URL,
+	})
+	require.NoError(t, err)
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "user",
+			url:   "https://github.com/jsvana",
+			reply: "[Github] Jay Vana(@jsvana) at Facebook - Bio bio bio",
+		},
+		{
+			name:  "repo",
+			url:   "https://github.com/jsvana/alfred",
+			reply: "[Github] jsvana/alfred [PHP] (forked from belak/alfred) Last pushed to 2 Jan 2015 - Description, 1 fork, 2 open issues, 4 stars",
+			fields: map[string]string{
+				"repo":     "jsvana/alfred",
+				"language": "PHP",
+				"stars":    "4",
+			},
+		},
+		{
+			name:  "issue",
+			url:   "https://github.com/belak/go-seabird/issues/42",
+			reply: "[Github] Issue #42 on belak/go-seabird [open] (assigned to jsvana) - Issue title [created 2 Jan 2015]",
+		},
+		{
+			name:  "pull",
+			url:   "https://github.com/belak/go-seabird/pull/59",
+			reply: "[Github] Pull request #59 on belak/go-seabird [open] created by jsvana - Title title title [created 4 Jan 2015], 1 commit, 4 comments, 2 changed files",
+		},
+		{
+			name:  "gist",
+			url:   "https://gist.github.com/belak/aa5a315d61ae9438b18d",
+			reply: "[Github] Created 3 Jan 2015 by belak - Description description, 3 comments",
+		},
+		{
+			name: "unknown path",
+			url:  "https://github.com/belak/go-seabird/tree/master/plugins",
+		},
+		{
+			name: "missing repo",
+			url:  "https://github.com/belak/missing",
+		},
+		{
+			name:   "api error",
+			url:    "https://github.com/belak/broken",
+			failed: true,
+		},
+	})
+}
+
+// headerRecorder records the Authorization header of every request.
+type headerRecorder struct {
+	auth []string
+}
+
+func (r *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.auth = append(r.auth, req.Header.Get("Authorization"))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestGithubProviderUnauthenticated(t *testing.T) {
+	server := newFixtureServer(t, "github", map[string]string{
+		"/users/jsvana": "user.json",
+	})
+
+	for _, token := range []string{"", "test-token"} {
+		recorder := &headerRecorder{}
+
+		p, err := NewGithubProvider(GithubOptions{
+			Token:      token,
+			HTTPClient: &http.Client{Transport: recorder},
+			BaseURL:    server.URL,
+		})
+		require.NoError(t, err)
+
+		runURLTests(t, p, []urlTestCase{
+			{
+				name:  "user",
+				url:   "https://github.com/jsvana",
+				reply: "[Github] Jay Vana(@jsvana) at Facebook - Bio bio bio",
+			},
+		})
+
+		if token == "" {
+			require.Equal(t, []string{""}, recorder.auth)
+		} else {
+			require.Equal(t, []string{"Bearer test-token"}, recorder.auth)
+		}
+	}
+}
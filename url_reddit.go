@@ -1,9 +1,13 @@
 package url
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/seabird-chat/seabird-go/pb"
 
@@ -43,7 +47,7 @@ type redditComment struct {
 }
 
 var (
-	redditPrefix = "[Reddit]"
+	redditName = "Reddit"
 
 	// /r/subreddit
 	redditPrivmsgSubRegex = regexp.MustCompile(`(?:\s|^)/?r/([^\s/]+)`)
@@ -56,52 +60,83 @@ var (
 	redditSubRegex     = regexp.MustCompile(`^/r/([^\s/]+)/?.*$`)
 )
 
-type RedditProvider struct{}
+const defaultRedditBaseURL = "https://www.reddit.com"
 
-func NewRedditProvider() *RedditProvider {
-	return &RedditProvider{}
+// RedditOptions configures a RedditProvider.
+type RedditOptions struct {
+	// HTTPClient is used for all API requests. Defaults to a
+	// client with the default timeout and limits.
+	HTTPClient *http.Client
+
+	// BaseURL is the root of the Reddit JSON API. Defaults to
+	// https://www.reddit.com.
+	BaseURL string
+}
+
+type RedditProvider struct {
+	http    *http.Client
+	baseURL string
 }
 
-func (p *RedditProvider) GetCallbacks() map[string]URLCallback {
-	return map[string]URLCallback{
-		"reddit.com":     redditCallback,
-		"old.reddit.com": redditCallback,
+func NewRedditProvider(opts RedditOptions) *RedditProvider {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = internal.NewDefaultHTTPClient()
+	}
+
+	if opts.BaseURL == "" {
+		opts.BaseURL = defaultRedditBaseURL
+	}
+
+	return &RedditProvider{
+		http:    opts.HTTPClient,
+		baseURL: strings.TrimSuffix(opts.BaseURL, "/"),
+	}
+}
+
+func (p *RedditProvider) Name() string {
+	return redditName
+}
+
+func (p *RedditProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
+		"reddit.com":     p.redditCallback,
+		"old.reddit.com": p.redditCallback,
 	}
 }
 
 func (p *RedditProvider) GetMessageCallback() MessageCallback {
-	return redditPrivmsgCallback
+	return p.redditPrivmsgCallback
 }
 
-func redditPrivmsgCallback(c *Client, source *pb.ChannelSource, text string) {
+func (p *RedditProvider) redditPrivmsgCallback(ctx context.Context, c *Client, source *pb.ChannelSource, text string) {
 	for _, matches := range redditPrivmsgSubRegex.FindAllStringSubmatch(text, -1) {
-		redditGetSub(c, source, matches[1])
+		c.ReplyPreview(source, p.getSub(ctx, matches[1]))
 	}
 
 	for _, matches := range redditPrivmsgUserRegex.FindAllStringSubmatch(text, -1) {
-		redditGetUser(c, source, matches[1])
+		c.ReplyPreview(source, p.getUser(ctx, matches[1]))
 	}
 }
 
-func redditCallback(c *Client, source *pb.ChannelSource, u *url.URL) bool {
+func (p *RedditProvider) redditCallback(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
 	text := u.Path
 
 	//nolint:gocritic
 	if matches := redditUserRegex.FindStringSubmatch(text); len(matches) == 2 {
-		return redditGetUser(c, source, matches[1])
+		return p.getUser(ctx, matches[1])
 	} else if matches := redditCommentRegex.FindStringSubmatch(text); len(matches) == 2 {
-		return redditGetComment(c, source, matches[1])
+		return p.getComment(ctx, matches[1])
 	} else if matches := redditSubRegex.FindStringSubmatch(text); len(matches) == 2 {
-		return redditGetSub(c, source, matches[1])
+		return p.getSub(ctx, matches[1])
 	}
 
-	return false
+	return nil
 }
 
-func redditGetUser(c *Client, source *pb.ChannelSource, text string) bool {
+func (p *RedditProvider) getUser(ctx context.Context, text string) *Preview {
 	ru := &redditUser{}
-	if err := internal.GetJSON(fmt.Sprintf("https://www.reddit.com/user/%s/about.json", text), ru); err != nil {
-		return false
+	if err := internal.GetJSON(ctx, p.http, fmt.Sprintf("%s/user/%s/about.json", p.baseURL, text), ru); err != nil {
+		return errorPreview(redditName, err)
 	}
 
 	// jsvana [gold] has 1 link karma and 1337 comment karma
@@ -110,40 +145,63 @@ func redditGetUser(c *Client, source *pb.ChannelSource, text string) bool {
 		gold = " [gold]"
 	}
 
-	c.Replyf(source, "%s %s%s has %d link karma and %d comment karma", redditPrefix, ru.Data.Name, gold, ru.Data.LinkKarma, ru.Data.CommentKarma)
-
-	return true
+	return &Preview{
+		Provider: redditName,
+		Title:    fmt.Sprintf("%s%s has %d link karma and %d comment karma", ru.Data.Name, gold, ru.Data.LinkKarma, ru.Data.CommentKarma),
+		Fields: []PreviewField{
+			{Name: "user", Value: ru.Data.Name},
+			{Name: "link_karma", Value: strconv.Itoa(ru.Data.LinkKarma)},
+			{Name: "comment_karma", Value: strconv.Itoa(ru.Data.CommentKarma)},
+		},
+	}
 }
 
-func redditGetComment(c *Client, source *pb.ChannelSource, text string) bool {
+func (p *RedditProvider) getComment(ctx context.Context, text string) *Preview {
 	rc := []redditComment{}
-	if err := internal.GetJSON(fmt.Sprintf("https://www.reddit.com/comments/%s.json", text), rc); err != nil || len(rc) < 1 {
-		return false
+	if err := internal.GetJSON(ctx, p.http, fmt.Sprintf("%s/comments/%s.json", p.baseURL, text), &rc); err != nil {
+		return errorPreview(redditName, err)
+	}
+
+	if len(rc) < 1 || len(rc[0].Data.Children) < 1 {
+		return nil
 	}
 
 	cm := rc[0].Data.Children[0].Data
 
 	// Title title - jsvana (/r/vim, score: 5)
-	c.Replyf(source, "%s %s - %s (/r/%s, score: %d)", redditPrefix, cm.Title, cm.Author, cm.Subreddit, cm.Score)
-
-	return true
+	return &Preview{
+		Provider: redditName,
+		Title:    fmt.Sprintf("%s - %s (/r/%s, score: %d)", cm.Title, cm.Author, cm.Subreddit, cm.Score),
+		Fields: []PreviewField{
+			{Name: "title", Value: cm.Title},
+			{Name: "author", Value: cm.Author},
+			{Name: "subreddit", Value: cm.Subreddit},
+			{Name: "score", Value: strconv.Itoa(cm.Score)},
+		},
+	}
 }
 
-func redditGetSub(c *Client, source *pb.ChannelSource, text string) bool {
+func (p *RedditProvider) getSub(ctx context.Context, text string) *Preview {
 	rs := &redditSub{}
-	if err := internal.GetJSON(fmt.Sprintf("https://www.reddit.com/r/%s/about.json", text), rs); err != nil {
-		return false
+	if err := internal.GetJSON(ctx, p.http, fmt.Sprintf("%s/r/%s/about.json", p.baseURL, text), rs); err != nil {
+		return errorPreview(redditName, err)
 	}
 
 	// /r/vim - Description description (1 subscriber, 2 actives)
-	c.Replyf(source, "%s %s - %s (%s %s, %s %s)",
-		redditPrefix,
-		rs.Data.URL,
-		rs.Data.Description,
-		internal.PrettifySuffix(rs.Data.Subscribers),
-		internal.PluralizeWord(rs.Data.Subscribers, "subscriber"),
-		internal.PrettifySuffix(rs.Data.Actives),
-		internal.PluralizeWord(rs.Data.Actives, "active"))
-
-	return true
+	return &Preview{
+		Provider: redditName,
+		Title: fmt.Sprintf("%s - %s (%s %s, %s %s)",
+			rs.Data.URL,
+			rs.Data.Description,
+			internal.PrettifySuffix(rs.Data.Subscribers),
+			internal.PluralizeWord(rs.Data.Subscribers, "subscriber"),
+			internal.PrettifySuffix(rs.Data.Actives),
+			internal.PluralizeWord(rs.Data.Actives, "active")),
+		Fields: []PreviewField{
+			{Name: "subreddit", Value: rs.Data.URL},
+			{Name: "description", Value: rs.Data.Description},
+			{Name: "subscribers", Value: strconv.Itoa(rs.Data.Subscribers)},
+			{Name: "actives", Value: strconv.Itoa(rs.Data.Actives)},
+		},
+	}
 }
@@ -0,0 +1,70 @@
+package url
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedditProvider(t *testing.T) *RedditProvider {
+	server := newFixtureServer(t, "reddit", map[string]string{
+		"/user/jsvana/about.json": "user.json",
+		"/r/vim/about.json":       "sub.json",
+		"/r/broken/about.json":    "500:",
+		"/comments/2r1ivh.json":   "comments.json",
+	})
+
+	return NewRedditProvider(RedditOptions{
+		HTTPClient: http.DefaultClient,
+		BaseURL:    server.URL,
+	})
+}
+
+func TestRedditProvider(t *testing.T) {
+	p := newTestRedditProvider(t)
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "user",
+			url:   "https://reddit.com/u/jsvana",
+			reply: "[Reddit] jsvana [gold] has 1 link karma and 1337 comment karma",
+		},
+		{
+			name:  "sub",
+			url:   "https://old.reddit.com/r/vim",
+			reply: "[Reddit] /r/vim/ - Description description (1.2K subscribers, 2 actives)",
+		},
+		{
+			name:  "comments",
+			url:   "https://reddit.com/r/vim/comments/2r1ivh/title_title",
+			reply: "[Reddit] Title title - jsvana (/r/vim, score: 5)",
+		},
+		{
+			name: "missing sub",
+			url:  "https://reddit.com/r/missing",
+		},
+		{
+			name:   "api error",
+			url:    "https://reddit.com/r/broken",
+			failed: true,
+		},
+		{
+			name: "unknown path",
+			url:  "https://reddit.com/prefs",
+		},
+	})
+}
+
+func TestRedditMessageCallback(t *testing.T) {
+	p := newTestRedditProvider(t)
+	c, fake := newTestClient(t)
+
+	p.GetMessageCallback()(context.Background(), c, testSource, "have you seen r/vim or /u/jsvana")
+
+	require.Equal(t, []string{
+		"[Reddit] /r/vim/ - Description description (1.2K subscribers, 2 actives)",
+		"[Reddit] jsvana [gold] has 1 link karma and 1337 comment karma",
+	}, fake.Messages())
+}
@@ -0,0 +1,278 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/seabird-chat/seabird-go/pb"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
+)
+
+var shortenerName = "Shortener"
+
+// DefaultShortenerHosts are the link shorteners expanded by default.
+var DefaultShortenerHosts = []string{
+	"bit.ly",
+	"t.co",
+	"tinyurl.com",
+	"goo.gl",
+	"ow.ly",
+	"buff.ly",
+	"is.gd",
+	"rb.gy",
+}
+
+// DefaultShortenerMaxHops is how many redirects are followed by default.
+const DefaultShortenerMaxHops = 5
+
+// ShortenerOptions configures a ShortenerProvider.
+type ShortenerOptions struct {
+	// Hosts are the shortener domains to expand. Defaults to
+	// DefaultShortenerHosts.
+	Hosts []string
+
+	// MaxHops is the most redirects which will be followed for a single
+	// link. Defaults to DefaultShortenerMaxHops.
+	MaxHops int
+}
+
+// NewShortenerProvider returns a provider which expands links from URL
+// shorteners and looks up where they end up. Because the links could point
+// anywhere, requests are made with the same client as page titles, so they
+// can't reach internal addresses.
+func NewShortenerProvider(opts ShortenerOptions) *ShortenerProvider {
+	if opts.Hosts == nil {
+		opts.Hosts = DefaultShortenerHosts
+	}
+
+	if opts.MaxHops == 0 {
+		opts.MaxHops = DefaultShortenerMaxHops
+	}
+
+	hosts := make(map[string]bool)
+	for _, host := range opts.Hosts {
+		hosts[normalizeDomain(host)] = true
+	}
+
+	return &ShortenerProvider{
+		hosts:   hosts,
+		maxHops: opts.MaxHops,
+	}
+}
+
+type ShortenerProvider struct {
+	hosts   map[string]bool
+	maxHops int
+}
+
+func (p *ShortenerProvider) Name() string {
+	return shortenerName
+}
+
+func (p *ShortenerProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	ret := make(map[string]PreviewCallback)
+	for host := range p.hosts {
+		ret[host] = p.handle
+	}
+
+	return ret
+}
+
+func (p *ShortenerProvider) GetMessageCallback() MessageCallback {
+	return nil
+}
+
+// errTooManyRedirects is returned when a short link is still redirecting
+// after the most hops we'll follow.
+var errTooManyRedirects = errors.New("too many redirects")
+
+func (p *ShortenerProvider) handle(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
+	// Only the short link itself needs a path. Something like bit.ly on its
+	// own is just the shortener's homepage.
+	if u.Path == "" {
+		return nil
+	}
+
+	shortDomain := normalizeDomain(u.Hostname())
+
+	target, problem, err := p.expand(ctx, c, u)
+
+	// Like links which were posted directly, previews are marked if any hop
+	// had an invalid certificate.
+	var annotations []string
+	if problem != "" {
+		annotations = append(annotations, certificateAnnotation(problem))
+	}
+
+	if errors.Is(err, errTooManyRedirects) {
+		// The link is probably a loop, so there's no destination to report,
+		// only the link itself.
+		return &Preview{
+			Provider:    shortenerName,
+			Title:       fmt.Sprintf("%s link which couldn't be resolved: %s", shortDomain, err),
+			URL:         u.String(),
+			Annotations: annotations,
+		}
+	}
+	if err != nil {
+		return errorPreview(shortenerName, err)
+	}
+	if target == nil {
+		return nil
+	}
+
+	policy := c.ChannelPolicy(source.GetChannelId())
+
+	// The short link got past the ignore lists, but where it goes might
+	// not. There's no point falling back to the page title in that case,
+	// since that would look up the same page.
+	if c.ignoresURL(policy, target.String()) {
+		return &Preview{Provider: shortenerName, sent: true}
+	}
+
+	// Like links which were posted directly, providers see the destination
+	// in its canonical form, but the page itself is fetched as it was given.
+	canonical := *target
+	canonicalizeURL(&canonical)
+
+	domain := normalizeDomain(canonical.Hostname())
+
+	fallback := &Preview{
+		Provider:    shortenerName,
+		Title:       fmt.Sprintf("%s link to %s", shortDomain, domain),
+		URL:         canonical.String(),
+		Annotations: annotations,
+	}
+
+	// If the link went to another shortener, looking it up would just end up
+	// back here.
+	if p.hosts[normalizeDomain(canonical.Host)] {
+		return fallback
+	}
+
+	// The lookup of the destination shares this lookup's time budget, rather
+	// than getting a whole new one.
+	preview := c.dispatchURL(ctx, source, &canonical, target.String(), policy)
+	if preview == nil || preview.Err != nil {
+		return fallback
+	}
+
+	// Previews from other providers don't say the link was shortened, so
+	// they're marked with where it went.
+	preview.Annotations = append(preview.Annotations, fmt.Sprintf("(%s)", domain))
+	for _, annotation := range annotations {
+		if !slices.Contains(preview.Annotations, annotation) {
+			preview.Annotations = append(preview.Annotations, annotation)
+		}
+	}
+
+	return preview
+}
+
+// expand follows redirects from u until it reaches a page which isn't a
+// redirect. It returns nil if u doesn't redirect anywhere, which means it
+// isn't a short link, and errTooManyRedirects if it's still redirecting after
+// the most hops allowed. Requests are made the same way as for page titles,
+// so problem describes the first invalid certificate along the way.
+func (p *ShortenerProvider) expand(ctx context.Context, c *Client, u *url.URL) (target *url.URL, problem string, err error) {
+	current := u
+	for hop := 0; ; hop++ {
+		// Redirects are followed by hand so each hop can be counted and so
+		// we know where they went even if a later hop fails.
+		next, hopProblem, err := p.follow(ctx, c, current)
+		if problem == "" {
+			problem = hopProblem
+		}
+		if err != nil {
+			// The first request is to the shortener itself, so there's
+			// nothing to report if that fails. Later failures still leave us
+			// knowing where the link went.
+			if hop == 0 {
+				if errors.Is(err, internal.ErrBlockedAddress) {
+					log.Printf("Not expanding %s: %s", u, err)
+					return nil, "", nil
+				}
+
+				return nil, "", err
+			}
+
+			log.Printf("Stopped expanding %s at %s: %s", u, current, err)
+			break
+		}
+
+		if next == nil {
+			break
+		}
+
+		if hop == p.maxHops {
+			return nil, problem, errTooManyRedirects
+		}
+
+		current = next
+	}
+
+	if current == u {
+		return nil, "", nil
+	}
+
+	return current, problem, nil
+}
+
+// follow makes a single request to u and returns where it redirects to, or
+// nil if it doesn't, along with any problem with its certificate. HEAD is
+// tried first since the body is never needed, but some servers don't support
+// it.
+func (p *ShortenerProvider) follow(ctx context.Context, c *Client, u *url.URL) (*url.URL, string, error) {
+	var (
+		resp    *http.Response
+		problem string
+	)
+
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp, problem, err = c.scrapeNoRedirect(req)
+		if err != nil {
+			return nil, "", err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 400 {
+			break
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, problem, nil
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return nil, problem, err
+	}
+
+	// Redirects can go through wrappers too, which are removed the same
+	// way as for links which were posted directly.
+	next := unwrapURL(location)
+
+	// Only redirects to other web pages are followed.
+	scheme := strings.ToLower(next.Scheme)
+	if (scheme != "http" && scheme != "https") || next.Host == "" {
+		return nil, problem, fmt.Errorf("unsupported redirect to %s", location)
+	}
+
+	return next, problem, nil
+}
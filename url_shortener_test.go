@@ -0,0 +1,181 @@
+package url
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
+)
+
+func TestShortenerProvider(t *testing.T) {
+	dest := newFixtureServer(t, "title", map[string]string{
+		"/other": "page.html",
+	})
+
+	var heads, gets int
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+		} else {
+			gets++
+		}
+
+		switch r.URL.Path {
+		case "/handled":
+			http.Redirect(w, r, dest.URL+"/handled?utm_source=short", http.StatusMovedPermanently)
+		case "/chain":
+			http.Redirect(w, r, "/handled", http.StatusFound)
+		case "/other":
+			// Some shorteners only support GET.
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.Redirect(w, r, dest.URL+"/other", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/missing":
+			http.Redirect(w, r, dest.URL+"/missing", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer short.Close()
+
+	c, _ := newTestClient(t)
+	c.Register(NewShortenerProvider(ShortenerOptions{Hosts: []string{strings.TrimPrefix(short.URL, "http://")}}))
+	c.Register(&testProvider{host: strings.TrimPrefix(dest.URL, "http://")})
+
+	// Short links are expanded and looked up with the provider for where
+	// they went.
+	preview := c.lookupURL(testSource, short.URL+"/handled")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Test] handled (127.0.0.1)", renderPreview(preview))
+	require.Equal(t, dest.URL+"/handled", preview.URL)
+	require.Equal(t, 1, heads)
+	require.Equal(t, 0, gets)
+
+	preview = c.lookupURL(testSource, short.URL+"/chain")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Test] handled (127.0.0.1)", renderPreview(preview))
+
+	// Page titles are marked with the domain the link went to too.
+	preview = c.lookupURL(testSource, short.URL+"/other")
+	require.NotNil(t, preview)
+	require.Equal(t, "Title: Example Domain (127.0.0.1)", strings.Join(strings.Fields(renderPreview(preview)), " "))
+
+	// If there's nothing else to show, the destination is still reported.
+	preview = c.lookupURL(testSource, short.URL+"/missing")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Shortener] 127.0.0.1 link to 127.0.0.1", renderPreview(preview))
+	require.Equal(t, dest.URL+"/missing", preview.URL)
+
+	// Redirect loops stop after a few hops, without claiming to know where
+	// the link went.
+	heads = 0
+	preview = c.lookupURL(testSource, short.URL+"/loop")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Shortener] 127.0.0.1 link which couldn't be resolved: too many redirects", renderPreview(preview))
+	require.Equal(t, short.URL+"/loop", preview.URL)
+	require.Equal(t, DefaultShortenerMaxHops+1, heads)
+
+	// Links which don't redirect aren't short links.
+	require.Nil(t, c.lookupURL(testSource, short.URL+"/plain"))
+
+	// The ignore lists also apply to where the link went.
+	var err error
+	c.ignoreRules, err = compileIgnoreRules([]string{urlHost(dest.URL) + "/handled"})
+	require.NoError(t, err)
+
+	preview = c.lookupURL(testSource, short.URL+"/handled")
+	require.NotNil(t, preview)
+	require.True(t, preview.sent)
+	c.ignoreRules = nil
+
+	// Short links are fetched with the same checks as page titles.
+	c.scrapeHTTP, err = internal.NewHTTPClient(Config{}.scrapeHTTPConfig(false))
+	require.NoError(t, err)
+
+	heads, gets = 0, 0
+	require.Nil(t, c.lookupURL(testSource, short.URL+"/handled"))
+	require.Equal(t, 0, heads+gets)
+}
+
+func TestShortenerProviderTimeout(t *testing.T) {
+	dest := newFixtureServer(t, "title", map[string]string{
+		"/page/": "page.html",
+	})
+
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hang":
+			http.Redirect(w, r, dest.URL+"/hang", http.StatusFound)
+		case "/page":
+			http.Redirect(w, r, dest.URL+"/page/?utm_source=short", http.StatusFound)
+		}
+	}))
+	defer short.Close()
+
+	c, _ := newTestClient(t)
+	c.timeouts = newLookupTimeouts(time.Minute, map[string]time.Duration{
+		"shortener": 50 * time.Millisecond,
+	})
+	c.Register(NewShortenerProvider(ShortenerOptions{Hosts: []string{strings.TrimPrefix(short.URL, "http://")}}))
+
+	p := &testProvider{host: strings.TrimPrefix(dest.URL, "http://")}
+	c.Register(p)
+
+	// Looking up where the link went counts against the shortener's
+	// timeout, rather than starting a new one.
+	start := time.Now()
+	preview := c.lookupURL(testSource, short.URL+"/hang")
+	require.Less(t, time.Since(start), time.Second)
+	require.NotNil(t, preview)
+	require.Equal(t, "[Shortener] 127.0.0.1 link to 127.0.0.1", renderPreview(preview))
+	require.Equal(t, int64(1), p.calls.Load())
+
+	// The page title comes from where the link actually went, not its
+	// canonical form.
+	preview = c.lookupURL(testSource, short.URL+"/page")
+	require.NotNil(t, preview)
+	require.Equal(t, "Title: Example Domain (127.0.0.1)", strings.Join(strings.Fields(renderPreview(preview)), " "))
+}
+
+func TestShortenerProviderInvalidCertificate(t *testing.T) {
+	dest := newFixtureServer(t, "title", map[string]string{})
+
+	short := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/handled":
+			http.Redirect(w, r, dest.URL+"/handled", http.StatusFound)
+		case "/missing":
+			http.Redirect(w, r, dest.URL+"/missing", http.StatusFound)
+		}
+	}))
+	defer short.Close()
+
+	c, _ := newTestClient(t)
+	c.Register(NewShortenerProvider(ShortenerOptions{Hosts: []string{strings.TrimPrefix(short.URL, "https://")}}))
+	c.Register(&testProvider{host: strings.TrimPrefix(dest.URL, "http://")})
+
+	// Short links are still expanded when the shortener's certificate isn't
+	// trusted, but the preview is marked like a page title would be.
+	preview := c.lookupURL(testSource, short.URL+"/handled")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Test] handled (127.0.0.1) [invalid certificate: self-signed]", renderPreview(preview))
+
+	preview = c.lookupURL(testSource, short.URL+"/missing")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Shortener] 127.0.0.1 link to 127.0.0.1 [invalid certificate: self-signed]", renderPreview(preview))
+
+	// Insecure hosts are never verified, so there's nothing to mark.
+	c.insecureHosts = []hostPattern{{host: "127.0.0.1"}}
+	preview = c.lookupURL(testSource, short.URL+"/handled")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Test] handled (127.0.0.1)", renderPreview(preview))
+}
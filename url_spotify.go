@@ -2,42 +2,46 @@ package url
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 	"text/template"
 
 	"github.com/seabird-chat/seabird-go/pb"
-	"github.com/zmb3/spotify"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 
 	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
-var spotifyPrefix = "[Spotify]"
+var spotifyName = "Spotify"
 
 type spotifyMatch struct {
+	kind     string
 	regex    *regexp.Regexp
 	uriRegex *regexp.Regexp
 	template *template.Template
-	lookup   func(spotify.Client, []string) interface{}
+	lookup   func(context.Context, *spotify.Client, []string) (interface{}, error)
 }
 
 var spotifyMatchers = []spotifyMatch{
 	{
+		kind:     "artist",
 		regex:    regexp.MustCompile(`^/artist/(.+)$`),
 		uriRegex: regexp.MustCompile(`\bspotify:artist:(\w+)\b`),
 		template: internal.TemplateMustCompile("spotifyArtist", `{{- .Name -}}`),
-		lookup: func(api spotify.Client, matches []string) interface{} {
-			artist, err := api.GetArtist(spotify.ID(matches[0]))
-			if err != nil {
-				log.Printf("Failed to get artist info from Spotify: %s", err)
-				return nil
-			}
-			return artist
+		lookup: func(ctx context.Context, api *spotify.Client, matches []string) (interface{}, error) {
+			return api.GetArtist(ctx, spotify.ID(matches[0]))
 		},
 	},
 	{
+		kind:     "album",
 		regex:    regexp.MustCompile(`^/album/(.+)$`),
 		uriRegex: regexp.MustCompile(`\bspotify:album:(\w+)\b`),
 		template: internal.TemplateMustCompile("spotifyAlbum", `
@@ -45,16 +49,12 @@ var spotifyMatchers = []spotifyMatch{
 			{{- range $index, $element := .Artists }}
 			{{- if $index }},{{ end }} {{ $element.Name -}}
 			{{- end }} ({{ pluralize .Tracks.Total "track" }})`),
-		lookup: func(api spotify.Client, matches []string) interface{} {
-			album, err := api.GetAlbum(spotify.ID(matches[0]))
-			if err != nil {
-				log.Printf("Failed to get album info from Spotify: %s", err)
-				return nil
-			}
-			return album
+		lookup: func(ctx context.Context, api *spotify.Client, matches []string) (interface{}, error) {
+			return api.GetAlbum(ctx, spotify.ID(matches[0]))
 		},
 	},
 	{
+		kind:     "track",
 		regex:    regexp.MustCompile(`^/track/(.+)$`),
 		uriRegex: regexp.MustCompile(`\bspotify:track:(\w+)\b`),
 		template: internal.TemplateMustCompile("spotifyTrack", `
@@ -62,49 +62,78 @@ var spotifyMatchers = []spotifyMatch{
 			{{- range $index, $element := .Artists }}
 			{{- if $index }},{{ end }} {{ $element.Name }}
 			{{- end }}`),
-		lookup: func(api spotify.Client, matches []string) interface{} {
-			track, err := api.GetTrack(spotify.ID(matches[0]))
-			if err != nil {
-				log.Printf("Failed to get track info from Spotify: %s", err)
-				return nil
-			}
-			return track
+		lookup: func(ctx context.Context, api *spotify.Client, matches []string) (interface{}, error) {
+			return api.GetTrack(ctx, spotify.ID(matches[0]))
 		},
 	},
 	{
+		kind:     "playlist",
 		regex:    regexp.MustCompile(`^/playlist/([^/]*)$`),
 		uriRegex: regexp.MustCompile(`\bspotify:playlist:(\w+)\b`),
 		template: internal.TemplateMustCompile("spotifyPlaylist", `
 			"{{- .Name }}" playlist by {{ .Owner.DisplayName }} ({{ pluralize .Tracks.Total "track" }})`),
-		lookup: func(api spotify.Client, matches []string) interface{} {
-			playlist, err := api.GetPlaylist(spotify.ID(matches[0]))
-			if err != nil {
-				log.Printf("Failed to get track info from Spotify: %s", err)
-				return nil
-			}
-			return playlist
+		lookup: func(ctx context.Context, api *spotify.Client, matches []string) (interface{}, error) {
+			return api.GetPlaylist(ctx, spotify.ID(matches[0]))
 		},
 	},
 }
 
+// SpotifyOptions configures a SpotifyProvider.
+type SpotifyOptions struct {
+	// ClientID and ClientSecret are the Spotify app credentials.
+	ClientID     string
+	ClientSecret string
+
+	// HTTPClient is used for all API requests. Defaults to a
+	// client with the default timeout and limits.
+	HTTPClient *http.Client
+
+	// BaseURL is the root of the Spotify Web API. Defaults to
+	// https://api.spotify.com/v1/.
+	BaseURL string
+
+	// TokenURL is where client credentials are exchanged for a token.
+	// Defaults to https://accounts.spotify.com/api/token.
+	TokenURL string
+}
+
 type SpotifyProvider struct {
-	client spotify.Client
+	client *spotify.Client
 }
 
-func NewSpotifyProvider(clientID, clientSecret string) (*SpotifyProvider, error) {
+func NewSpotifyProvider(opts SpotifyOptions) (*SpotifyProvider, error) {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = internal.NewDefaultHTTPClient()
+	}
+
+	if opts.TokenURL == "" {
+		opts.TokenURL = spotifyauth.TokenURL
+	}
+
 	config := &clientcredentials.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		TokenURL:     spotify.TokenURL,
+		ClientID:     opts.ClientID,
+		ClientSecret: opts.ClientSecret,
+		TokenURL:     opts.TokenURL,
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, opts.HTTPClient)
+
+	var clientOpts []spotify.ClientOption
+	if opts.BaseURL != "" {
+		clientOpts = append(clientOpts, spotify.WithBaseURL(strings.TrimSuffix(opts.BaseURL, "/")+"/"))
 	}
 
 	return &SpotifyProvider{
-		client: spotify.NewClient(config.Client(context.Background())),
+		client: spotify.New(config.Client(ctx), clientOpts...),
 	}, nil
 }
 
-func (p *SpotifyProvider) GetCallbacks() map[string]URLCallback {
-	return map[string]URLCallback{
+func (p *SpotifyProvider) Name() string {
+	return spotifyName
+}
+
+func (p *SpotifyProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
 		"open.spotify.com": p.handleURL,
 	}
 }
@@ -113,47 +142,66 @@ func (p *SpotifyProvider) GetMessageCallback() MessageCallback {
 	return p.msgCallback
 }
 
-func (p *SpotifyProvider) msgCallback(c *Client, source *pb.ChannelSource, text string) {
+func (p *SpotifyProvider) msgCallback(ctx context.Context, c *Client, source *pb.ChannelSource, text string) {
+	// URIs in code are usually examples, the same as links.
+	text = stripCodeSpans(text)
+
 	for _, matcher := range spotifyMatchers {
 		// TODO: handle multiple matches in one message
-		if ok := p.handleTarget(matcher, matcher.uriRegex, c, source, text); ok {
+		if preview := p.handleTarget(ctx, matcher, matcher.uriRegex, text); preview != nil {
+			if preview.Err != nil {
+				log.Printf("Failed to look up Spotify URI: %s", preview.Err)
+			}
+
+			c.ReplyPreview(source, preview)
 			return
 		}
 	}
 }
 
-func (p *SpotifyProvider) handleURL(c *Client, source *pb.ChannelSource, u *url.URL) bool {
+func (p *SpotifyProvider) handleURL(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
 	for _, matcher := range spotifyMatchers {
-		if p.handleTarget(matcher, matcher.regex, c, source, u.Path) {
-			return true
+		if preview := p.handleTarget(ctx, matcher, matcher.regex, u.Path); preview != nil {
+			return preview
 		}
 	}
 
-	return false
+	return nil
 }
 
-func (p *SpotifyProvider) handleTarget(matcher spotifyMatch, regex *regexp.Regexp, c *Client, source *pb.ChannelSource, target string) bool {
+func (p *SpotifyProvider) handleTarget(ctx context.Context, matcher spotifyMatch, regex *regexp.Regexp, target string) *Preview {
 	if !regex.MatchString(target) {
-		return false
+		return nil
 	}
 
 	matches := regex.FindStringSubmatch(target)
 	if len(matches) != 2 {
-		return false
+		return nil
 	}
 
-	data := matcher.lookup(p.client, matches[1:])
-	if data == nil {
-		return false
+	data, err := matcher.lookup(ctx, p.client, matches[1:])
+	if err != nil {
+		// Spotify returns a 400 for malformed IDs and a 404 for unknown
+		// ones, neither of which means the lookup actually failed.
+		var spotifyErr spotify.Error
+		if errors.As(err, &spotifyErr) && (spotifyErr.Status == http.StatusBadRequest || spotifyErr.Status == http.StatusNotFound) {
+			return nil
+		}
+
+		return errorPreview(spotifyName, fmt.Errorf("failed to get %s: %w", matcher.kind, err))
 	}
 
-	msg, err := internal.RenderTemplate(matcher.template, spotifyPrefix, data)
+	msg, err := internal.ExecuteTemplate(matcher.template, data)
 	if err != nil {
-		log.Printf("Failed to render template: %s", err)
-		return false
+		return errorPreview(spotifyName, err)
 	}
 
-	c.Reply(source, msg)
-
-	return true
+	return &Preview{
+		Provider: spotifyName,
+		Title:    msg,
+		Fields: []PreviewField{
+			{Name: "type", Value: matcher.kind},
+			{Name: "id", Value: matches[1]},
+		},
+	}
 }
@@ -0,0 +1,113 @@
+package url
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	seabird "github.com/seabird-chat/seabird-go"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSpotifyProvider(t *testing.T) *SpotifyProvider {
+	server := newFixtureServer(t, "spotify", map[string]string{
+		"/api/token":                           "token.json",
+		"/v1/artists/0OdUWJ0sBjDrqHygGUXeCF":   "artist.json",
+		"/v1/albums/0sNOF9WDwhWunNAHPD3Baj":    "album.json",
+		"/v1/tracks/6rqhFgbbKwnb9MLmUQDhG6":    "track.json",
+		"/v1/playlists/37i9dQZF1DXcBWIGoYBM5M": "playlist.json",
+		"/v1/tracks/missing":                   "404:not_found.json",
+		"/v1/artists/broken":                   "500:",
+	})
+
+	p, err := NewSpotifyProvider(SpotifyOptions{
+		ClientID:     "test-id",
+		ClientSecret: "test-secret",
+		HTTPClient:   http.DefaultClient,
+		BaseURL:      server.URL + "/v1",
+		TokenURL:     server.URL + "/api/token",
+	})
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestSpotifyProvider(t *testing.T) {
+	p := newTestSpotifyProvider(t)
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "artist",
+			url:   "https://open.spotify.com/artist/0OdUWJ0sBjDrqHygGUXeCF",
+			reply: "[Spotify] Band of Horses",
+		},
+		{
+			name:  "album",
+			url:   "https://open.spotify.com/album/0sNOF9WDwhWunNAHPD3Baj",
+			reply: "[Spotify] Everything All the Time by Band of Horses (10 tracks)",
+		},
+		{
+			name:  "track",
+			url:   "https://open.spotify.com/track/6rqhFgbbKwnb9MLmUQDhG6",
+			reply: `[Spotify] "The Funeral" from Everything All the Time by Band of Horses, Guest Artist`,
+		},
+		{
+			name:  "playlist",
+			url:   "https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M",
+			reply: `[Spotify] "Today's Top Hits" playlist by Spotify (50 tracks)`,
+		},
+		{
+			name: "missing track",
+			url:  "https://open.spotify.com/track/missing",
+		},
+		{
+			name: "unknown path",
+			url:  "https://open.spotify.com/show/abc",
+		},
+		{
+			name:   "api error",
+			url:    "https://open.spotify.com/artist/broken",
+			failed: true,
+		},
+	})
+}
+
+func TestSpotifyMessageCallback(t *testing.T) {
+	p := newTestSpotifyProvider(t)
+	c, fake := newTestClient(t)
+
+	p.GetMessageCallback()(context.Background(), c, testSource, "listen to spotify:track:6rqhFgbbKwnb9MLmUQDhG6")
+
+	require.Equal(t, []string{
+		`[Spotify] "The Funeral" from Everything All the Time by Band of Horses, Guest Artist`,
+	}, fake.Messages())
+}
+
+func TestSpotifyMessageCallbackSkipsCode(t *testing.T) {
+	p := newTestSpotifyProvider(t)
+	c, fake := newTestClient(t)
+
+	p.GetMessageCallback()(context.Background(), c, testSource, "try `spotify:track:6rqhFgbbKwnb9MLmUQDhG6` in the search bar")
+	require.Empty(t, fake.Messages())
+
+	// Messages with blocks are matched against their text, without any code
+	// or quotes.
+	c.Register(p)
+	c.messageCallback(testSource, "spotify:track:6rqhFgbbKwnb9MLmUQDhG6", seabird.NewContainerBlock(
+		seabird.NewTextBlock("try "),
+		seabird.NewInlineCodeBlock("spotify:track:6rqhFgbbKwnb9MLmUQDhG6"),
+		seabird.NewFencedCodeBlock("", "spotify:track:6rqhFgbbKwnb9MLmUQDhG6"),
+		seabird.NewBlockquoteBlock(seabird.NewTextBlock("spotify:track:6rqhFgbbKwnb9MLmUQDhG6")),
+	))
+	c.inFlight.Wait()
+	require.Empty(t, fake.Messages())
+
+	c.messageCallback(testSource, "", seabird.NewContainerBlock(
+		seabird.NewTextBlock("listen to "),
+		seabird.NewBoldBlock(seabird.NewTextBlock("spotify:track:6rqhFgbbKwnb9MLmUQDhG6")),
+	))
+	c.inFlight.Wait()
+	require.Equal(t, []string{
+		`[Spotify] "The Funeral" from Everything All the Time by Band of Horses, Guest Artist`,
+	}, fake.Messages())
+}
@@ -0,0 +1,143 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	seabird "github.com/seabird-chat/seabird-go"
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+// testProvider is a Provider which returns canned results based on the URL
+// path.
+type testProvider struct {
+	host  string
+	calls atomic.Int64
+}
+
+func (p *testProvider) Name() string {
+	return "Test"
+}
+
+func (p *testProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
+		p.host: func(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
+			p.calls.Add(1)
+
+			switch u.Path {
+			case "/handled":
+				return &Preview{Provider: "Test", Title: "handled"}
+			case "/slow":
+				time.Sleep(50 * time.Millisecond)
+				return &Preview{Provider: "Test", Title: "slow"}
+			case "/failed":
+				return &Preview{Provider: "Test", Err: errors.New("lookup failed")}
+			case "/hang":
+				<-ctx.Done()
+				return &Preview{Provider: "Test", Err: ctx.Err()}
+			}
+
+			return nil
+		},
+	}
+}
+
+func (p *testProvider) GetMessageCallback() MessageCallback {
+	return nil
+}
+
+func TestLookupURL(t *testing.T) {
+	server := newFixtureServer(t, "title", map[string]string{
+		"/handled": "page.html",
+		"/failed":  "page.html",
+		"/other":   "page.html",
+	})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, _ := newTestClient(t)
+	p := &testProvider{host: host}
+	c.Register(p)
+
+	preview := c.lookupURL(testSource, server.URL+"/handled")
+	require.NotNil(t, preview)
+	require.Equal(t, "[Test] handled", renderPreview(preview))
+	require.Equal(t, server.URL+"/handled", preview.URL)
+
+	// URLs the provider doesn't handle fall back to the page title.
+	preview = c.lookupURL(testSource, server.URL+"/other")
+	require.NotNil(t, preview)
+	require.Equal(t, "Title: Example Domain", strings.Join(strings.Fields(renderPreview(preview)), " "))
+
+	// By default, failed lookups also fall back to the page title.
+	preview = c.lookupURL(testSource, server.URL+"/failed")
+	require.NotNil(t, preview)
+	require.Equal(t, titleProviderName, preview.Provider)
+
+	c.quietOnError = true
+
+	require.Nil(t, c.lookupURL(testSource, server.URL+"/failed"))
+
+	// Quiet mode only applies to failures, not unhandled URLs.
+	preview = c.lookupURL(testSource, server.URL+"/other")
+	require.NotNil(t, preview)
+	require.Equal(t, titleProviderName, preview.Provider)
+
+	require.Equal(t, map[string]LookupStats{
+		"Test": {
+			Handled:    1,
+			NotHandled: 2,
+			Failed:     2,
+		},
+		titleProviderName: {
+			Handled: 3,
+		},
+	}, c.LookupStats())
+}
+
+func TestLookupURLTimeout(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.quietOnError = true
+	c.timeouts = newLookupTimeouts(time.Minute, map[string]time.Duration{
+		"test": 10 * time.Millisecond,
+	})
+
+	p := &testProvider{host: "example.com"}
+	c.Register(p)
+
+	// The provider's own timeout applies rather than the default.
+	start := time.Now()
+	require.Nil(t, c.lookupURL(testSource, "https://example.com/hang"))
+	require.Less(t, time.Since(start), time.Minute)
+	require.Equal(t, LookupStats{Failed: 1}, c.LookupStats()["Test"])
+
+	// Once the client shuts down, lookups give up right away.
+	ctx, cancel := context.WithCancel(context.Background())
+	c.lookupCtx = ctx
+	c.timeouts = newLookupTimeouts(time.Minute, nil)
+	cancel()
+
+	require.Nil(t, c.lookupURL(testSource, "https://example.com/hang?again=1"))
+	require.Equal(t, LookupStats{Failed: 2}, c.LookupStats()["Test"])
+}
+
+func TestExtractURLsFromBlocks(t *testing.T) {
+	root := seabird.NewContainerBlock(
+		seabird.NewTextBlock("look at "),
+		seabird.NewLinkBlock("https://example.com/a", seabird.NewTextBlock("this")),
+		seabird.NewBlockquoteBlock(seabird.NewLinkBlock("https://example.com/quoted")),
+		seabird.NewInlineCodeBlock("https://example.com/code"),
+		seabird.NewFencedCodeBlock("", "https://example.com/fenced"),
+		seabird.NewSpoilerBlock(seabird.NewBoldBlock(seabird.NewLinkBlock("https://example.com/spoiler"))),
+	)
+
+	require.Equal(t, []blockLink{
+		{url: "https://example.com/a"},
+		{url: "https://example.com/spoiler", spoiler: true},
+	}, extractURLsFromBlocks(root, false))
+}
@@ -1,7 +1,9 @@
 package url
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -13,12 +15,26 @@ import (
 
 // The X API no longer has a free read tier, so tweet and user data comes from
 // FixTweet's public API instead.
-const twitterAPIBase = "https://api.fxtwitter.com"
+const defaultTwitterBaseURL = "https://api.fxtwitter.com"
 
-type TwitterProvider struct{}
+// TwitterOptions configures a TwitterProvider.
+type TwitterOptions struct {
+	// HTTPClient is used for all API requests. Defaults to a
+	// client with the default timeout and limits.
+	HTTPClient *http.Client
+
+	// BaseURL is the root of the FixTweet API. Defaults to
+	// https://api.fxtwitter.com.
+	BaseURL string
+}
+
+type TwitterProvider struct {
+	http    *http.Client
+	baseURL string
+}
 
 var (
-	twitterPrefix = "[Twitter]"
+	twitterName = "Twitter"
 
 	// @username
 	twitterPrivmsgUserRegex = regexp.MustCompile(`(?:\s|^)@(\w+)`)
@@ -48,12 +64,27 @@ type twitterUser struct {
 	} `json:"user"`
 }
 
-func NewTwitterProvider() *TwitterProvider {
-	return &TwitterProvider{}
+func NewTwitterProvider(opts TwitterOptions) *TwitterProvider {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = internal.NewDefaultHTTPClient()
+	}
+
+	if opts.BaseURL == "" {
+		opts.BaseURL = defaultTwitterBaseURL
+	}
+
+	return &TwitterProvider{
+		http:    opts.HTTPClient,
+		baseURL: strings.TrimSuffix(opts.BaseURL, "/"),
+	}
+}
+
+func (p *TwitterProvider) Name() string {
+	return twitterName
 }
 
-func (p *TwitterProvider) GetCallbacks() map[string]URLCallback {
-	return map[string]URLCallback{
+func (p *TwitterProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
 		"twitter.com": p.handle,
 		"x.com":       p.handle,
 	}
@@ -64,55 +95,71 @@ func (p *TwitterProvider) GetMessageCallback() MessageCallback {
 	return nil
 }
 
-func (p *TwitterProvider) msgCallback(c *Client, source *pb.ChannelSource, text string) {
+func (p *TwitterProvider) msgCallback(ctx context.Context, c *Client, source *pb.ChannelSource, text string) {
 	for _, matches := range twitterPrivmsgUserRegex.FindAllStringSubmatch(text, -1) {
-		p.getUser(c, source, matches[1])
+		c.ReplyPreview(source, p.getUser(ctx, matches[1]))
 	}
 }
 
-func (p *TwitterProvider) handle(c *Client, source *pb.ChannelSource, u *url.URL) bool {
+func (p *TwitterProvider) handle(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
 	if matches := twitterStatusRegex.FindStringSubmatch(u.Path); len(matches) == 2 {
-		return p.getTweet(c, source, matches[1])
+		return p.getTweet(ctx, matches[1])
 	} else if matches := twitterUserRegex.FindStringSubmatch(u.Path); len(matches) == 2 {
-		return p.getUser(c, source, matches[1])
+		return p.getUser(ctx, matches[1])
 	}
 
-	return false
+	return nil
 }
 
-func (p *TwitterProvider) getUser(c *Client, source *pb.ChannelSource, name string) bool {
+func (p *TwitterProvider) getUser(ctx context.Context, name string) *Preview {
 	var resp twitterUser
 
-	err := internal.GetJSON(fmt.Sprintf("%s/%s", twitterAPIBase, url.PathEscape(name)), &resp)
-	if err != nil || resp.User == nil {
-		return false
+	err := internal.GetJSON(ctx, p.http, fmt.Sprintf("%s/%s", p.baseURL, url.PathEscape(name)), &resp)
+	if err != nil {
+		return errorPreview(twitterName, err)
 	}
 
-	// Jay Vana (@jsvana) - Description description
-	c.Replyf(source, "%s %s (@%s) - %s",
-		twitterPrefix,
-		resp.User.Name,
-		resp.User.ScreenName,
-		twitterCleanText(resp.User.Description))
+	if resp.User == nil {
+		return nil
+	}
 
-	return true
+	// Jay Vana (@jsvana) - Description description
+	return &Preview{
+		Provider: twitterName,
+		Title: fmt.Sprintf("%s (@%s) - %s",
+			resp.User.Name,
+			resp.User.ScreenName,
+			twitterCleanText(resp.User.Description)),
+		Fields: []PreviewField{
+			{Name: "name", Value: resp.User.Name},
+			{Name: "screen_name", Value: resp.User.ScreenName},
+		},
+	}
 }
 
-func (p *TwitterProvider) getTweet(c *Client, source *pb.ChannelSource, id string) bool {
+func (p *TwitterProvider) getTweet(ctx context.Context, id string) *Preview {
 	var resp twitterTweet
 
-	err := internal.GetJSON(fmt.Sprintf("%s/status/%s", twitterAPIBase, id), &resp)
-	if err != nil || resp.Tweet == nil {
-		return false
+	err := internal.GetJSON(ctx, p.http, fmt.Sprintf("%s/status/%s", p.baseURL, id), &resp)
+	if err != nil {
+		return errorPreview(twitterName, err)
 	}
 
-	// Tweet text (@jsvana)
-	c.Replyf(source, "%s %s (@%s)",
-		twitterPrefix,
-		twitterCleanText(resp.Tweet.Text),
-		resp.Tweet.Author.ScreenName)
+	if resp.Tweet == nil {
+		return nil
+	}
 
-	return true
+	// Tweet text (@jsvana)
+	return &Preview{
+		Provider: twitterName,
+		Title: fmt.Sprintf("%s (@%s)",
+			twitterCleanText(resp.Tweet.Text),
+			resp.Tweet.Author.ScreenName),
+		Fields: []PreviewField{
+			{Name: "id", Value: id},
+			{Name: "author", Value: resp.Tweet.Author.ScreenName},
+		},
+	}
 }
 
 // twitterCleanText collapses newlines so multi-line tweets and bios stay on a
@@ -0,0 +1,45 @@
+package url
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTwitterProvider(t *testing.T) {
+	server := newFixtureServer(t, "twitter", map[string]string{
+		"/jsvana":                    "user.json",
+		"/status/560070183650213889": "tweet.json",
+		"/status/2":                  "500:",
+	})
+
+	p := NewTwitterProvider(TwitterOptions{
+		HTTPClient: http.DefaultClient,
+		BaseURL:    server.URL,
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "user",
+			url:   "https://twitter.com/jsvana",
+			reply: "[Twitter] Jay Vana (@jsvana) - Description description",
+		},
+		{
+			name:  "tweet",
+			url:   "https://x.com/jsvana/status/560070183650213889",
+			reply: "[Twitter] Tweet text over two lines (@jsvana)",
+		},
+		{
+			name: "missing tweet",
+			url:  "https://x.com/jsvana/status/1",
+		},
+		{
+			name: "unknown path",
+			url:  "https://x.com/jsvana/likes",
+		},
+		{
+			name:   "api error",
+			url:    "https://x.com/jsvana/status/2",
+			failed: true,
+		},
+	})
+}
@@ -1,29 +1,65 @@
 package url
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 
 	"github.com/seabird-chat/seabird-go/pb"
 	"github.com/yhat/scrape"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+
+	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
 var xkcdRegex = regexp.MustCompile(`^/([^/]+)$`)
-var xkcdPrefix = "[XKCD]"
+var xkcdName = "XKCD"
+
+const defaultXKCDBaseURL = "https://xkcd.com"
+
+// XKCDOptions configures an XKCDProvider.
+type XKCDOptions struct {
+	// HTTPClient is used for all requests. Defaults to a client
+	// with the default timeout and limits.
+	HTTPClient *http.Client
+
+	// BaseURL is where comic pages are fetched from. Defaults to
+	// https://xkcd.com.
+	BaseURL string
+}
+
+func NewXKCDProvider(opts XKCDOptions) *XKCDProvider {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = internal.NewDefaultHTTPClient()
+	}
+
+	if opts.BaseURL == "" {
+		opts.BaseURL = defaultXKCDBaseURL
+	}
+
+	return &XKCDProvider{
+		http:    opts.HTTPClient,
+		baseURL: strings.TrimSuffix(opts.BaseURL, "/"),
+	}
+}
 
-func NewXKCDProvider() *XKCDProvider {
-	return &XKCDProvider{}
+type XKCDProvider struct {
+	http    *http.Client
+	baseURL string
 }
 
-type XKCDProvider struct{}
+func (p *XKCDProvider) Name() string {
+	return xkcdName
+}
 
-func (p *XKCDProvider) GetCallbacks() map[string]URLCallback {
-	return map[string]URLCallback{
-		"xkcd.com": handleXKCD,
+func (p *XKCDProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
+		"xkcd.com": p.handleXKCD,
 	}
 }
 
@@ -31,39 +67,53 @@ func (p *XKCDProvider) GetMessageCallback() MessageCallback {
 	return nil
 }
 
-func handleXKCD(c *Client, source *pb.ChannelSource, u *url.URL) bool {
+func (p *XKCDProvider) handleXKCD(ctx context.Context, c *Client, source *pb.ChannelSource, u *url.URL) *Preview {
 	if u.Path != "" && !xkcdRegex.MatchString(u.Path) {
-		return false
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+u.EscapedPath(), nil)
+	if err != nil {
+		return errorPreview(xkcdName, err)
 	}
 
-	resp, err := http.Get(u.String())
+	resp, err := p.http.Do(req)
 	if err != nil {
-		return false
+		return errorPreview(xkcdName, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
 	if resp.StatusCode != 200 {
-		return false
+		return errorPreview(xkcdName, fmt.Errorf("unexpected status %d", resp.StatusCode))
 	}
 
 	// We search the first 1K and if a title isn't in there, we deal with it
 	z, err := html.Parse(io.LimitReader(resp.Body, 1024*1024))
 	if err != nil {
-		return false
+		return errorPreview(xkcdName, err)
 	}
 
 	// Scrape the tree for the first title node we find
 	n, ok := scrape.Find(z, scrape.ById("comic"))
 	if !ok {
-		return false
+		return nil
 	}
 
 	n, ok = scrape.Find(n, scrape.ByTag(atom.Img))
 	if !ok {
-		return false
+		return nil
 	}
 
-	c.Replyf(source, "%s %s: %s", xkcdPrefix, scrape.Attr(n, "alt"), scrape.Attr(n, "title"))
-
-	return true
+	return &Preview{
+		Provider: xkcdName,
+		Title:    fmt.Sprintf("%s: %s", scrape.Attr(n, "alt"), scrape.Attr(n, "title")),
+		Fields: []PreviewField{
+			{Name: "title", Value: scrape.Attr(n, "alt")},
+			{Name: "alt", Value: scrape.Attr(n, "title")},
+		},
+	}
 }
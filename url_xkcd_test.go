@@ -0,0 +1,39 @@
+package url
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestXKCDProvider(t *testing.T) {
+	server := newFixtureServer(t, "xkcd", map[string]string{
+		"/353": "353.html",
+		"/500": "500:",
+	})
+
+	p := NewXKCDProvider(XKCDOptions{
+		HTTPClient: http.DefaultClient,
+		BaseURL:    server.URL,
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "comic",
+			url:   "https://xkcd.com/353",
+			reply: "[XKCD] Python: I wrote 20 short programs in Python yesterday.  It was wonderful.  Perl, I'm leaving you.",
+		},
+		{
+			name: "missing comic",
+			url:  "https://xkcd.com/404",
+		},
+		{
+			name:   "server error",
+			url:    "https://xkcd.com/500",
+			failed: true,
+		},
+		{
+			name: "unknown path",
+			url:  "https://xkcd.com/about/index.html",
+		},
+	})
+}
@@ -1,7 +1,9 @@
 package url
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 
@@ -11,7 +13,7 @@ import (
 	"github.com/seabird-chat/seabird-url-plugin/internal"
 )
 
-var youtubePrefix = "[YouTube]"
+var youtubeName = "YouTube"
 
 // videos was converted using https://github.com/ChimeraCoder/gojson
 type ytVideos struct {
@@ -28,16 +30,70 @@ type ytVideos struct {
 	} `json:"items"`
 }
 
-func NewYoutubeProvider(token string) *YoutubeProvider {
-	return &YoutubeProvider{token: token}
+// ytOEmbed is the subset of an oEmbed response we care about.
+type ytOEmbed struct {
+	Title      string `json:"title"`
+	AuthorName string `json:"author_name"`
+}
+
+const (
+	defaultYoutubeBaseURL   = "https://www.googleapis.com/youtube/v3"
+	defaultYoutubeOEmbedURL = "https://www.youtube.com/oembed"
+)
+
+// YoutubeOptions configures a YoutubeProvider.
+type YoutubeOptions struct {
+	// Token is the YouTube Data API key. If it is empty, videos are looked up
+	// with oEmbed instead, which doesn't include the duration.
+	Token string
+
+	// HTTPClient is used for all API requests. Defaults to a
+	// client with the default timeout and limits.
+	HTTPClient *http.Client
+
+	// BaseURL is the root of the YouTube Data API. Defaults to
+	// https://www.googleapis.com/youtube/v3.
+	BaseURL string
+
+	// OEmbedURL is the oEmbed endpoint used when there is no Token. Defaults
+	// to https://www.youtube.com/oembed.
+	OEmbedURL string
+}
+
+func NewYoutubeProvider(opts YoutubeOptions) *YoutubeProvider {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = internal.NewDefaultHTTPClient()
+	}
+
+	if opts.BaseURL == "" {
+		opts.BaseURL = defaultYoutubeBaseURL
+	}
+
+	if opts.OEmbedURL == "" {
+		opts.OEmbedURL = defaultYoutubeOEmbedURL
+	}
+
+	return &YoutubeProvider{
+		token:     opts.Token,
+		http:      opts.HTTPClient,
+		baseURL:   strings.TrimSuffix(opts.BaseURL, "/"),
+		oEmbedURL: opts.OEmbedURL,
+	}
 }
 
 type YoutubeProvider struct {
-	token string
+	token     string
+	http      *http.Client
+	baseURL   string
+	oEmbedURL string
 }
 
-func (p *YoutubeProvider) GetCallbacks() map[string]URLCallback {
-	return map[string]URLCallback{
+func (p *YoutubeProvider) Name() string {
+	return youtubeName
+}
+
+func (p *YoutubeProvider) GetPreviewCallbacks() map[string]PreviewCallback {
+	return map[string]PreviewCallback{
 		"youtube.com":       p.handle,
 		"m.youtube.com":     p.handle,
 		"youtu.be":          p.handle,
@@ -49,49 +105,114 @@ func (p *YoutubeProvider) GetMessageCallback() MessageCallback {
 	return nil
 }
 
-func (p *YoutubeProvider) handle(c *Client, source *pb.ChannelSource, req *url.URL) bool {
-	// Get the Video ID from the URL
-	values, _ := url.ParseQuery(req.RawQuery)
+// youtubeVideoPaths are the paths on youtube.com which are followed by a
+// video ID.
+var youtubeVideoPaths = map[string]bool{
+	"shorts": true,
+	"embed":  true,
+	"live":   true,
+	"v":      true,
+}
 
-	var id string
+// youtubeVideoID returns the ID of the video a URL points to, or an empty
+// string if it's something else, like a channel or a playlist.
+func youtubeVideoID(u *url.URL) string {
+	values, _ := url.ParseQuery(u.RawQuery)
 
+	// using full www.youtube.com/?v=bbq
 	if len(values["v"]) > 0 {
-		// using full www.youtube.com/?v=bbq
-		id = values["v"][0]
-	} else {
-		// using short youtu.be/bbq
-		path := strings.Split(req.Path, "/")
-		if len(path) < 1 {
-			return false
-		}
-		id = path[1]
+		return values["v"][0]
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	// using short youtu.be/bbq
+	if u.Hostname() == "youtu.be" {
+		return path[0]
+	}
+
+	// using youtube.com/shorts/bbq and similar
+	if len(path) == 2 && youtubeVideoPaths[path[0]] {
+		return path[1]
+	}
+
+	return ""
+}
+
+func (p *YoutubeProvider) handle(ctx context.Context, c *Client, source *pb.ChannelSource, req *url.URL) *Preview {
+	// Get the Video ID from the URL. Anything which isn't a video is left
+	// for the page title.
+	id := youtubeVideoID(req)
+	if id == "" {
+		return nil
+	}
+
+	// Without an API key, we can only get the title.
+	if p.token == "" {
+		return p.handleOEmbed(ctx, id)
 	}
 
 	// Get video duration and title
-	time, title := getVideo(id, p.token)
+	time, title, err := p.getVideo(ctx, id)
+	if err != nil {
+		return errorPreview(youtubeName, err)
+	}
 
 	// Invalid video ID or no results
 	if time == "" && title == "" {
-		return false
+		return nil
+	}
+
+	return &Preview{
+		Provider: youtubeName,
+		Title:    fmt.Sprintf("%s ~ %s", time, title),
+		Fields: []PreviewField{
+			{Name: "id", Value: id},
+			{Name: "duration", Value: time},
+			{Name: "title", Value: title},
+		},
 	}
+}
+
+func (p *YoutubeProvider) handleOEmbed(ctx context.Context, id string) *Preview {
+	api := fmt.Sprintf("%s?format=json&url=%s", p.oEmbedURL, url.QueryEscape("https://www.youtube.com/watch?v="+id))
 
-	c.Replyf(source, "%s %s ~ %s", youtubePrefix, time, title)
+	var video ytOEmbed
+	if err := internal.GetJSON(ctx, p.http, api, &video); err != nil {
+		return errorPreview(youtubeName, err)
+	}
 
-	return true
+	if video.Title == "" {
+		return nil
+	}
+
+	title := video.Title
+	if video.AuthorName != "" {
+		title += " by " + video.AuthorName
+	}
+
+	return &Preview{
+		Provider: youtubeName,
+		Title:    title,
+		Fields: []PreviewField{
+			{Name: "id", Value: id},
+			{Name: "title", Value: title},
+		},
+	}
 }
 
-func getVideo(id string, key string) (time string, title string) {
+func (p *YoutubeProvider) getVideo(ctx context.Context, id string) (time string, title string, err error) {
 	// Build the API call
-	api := fmt.Sprintf("https://www.googleapis.com/youtube/v3/videos?part=contentDetails%%2Csnippet&id=%s&fields=items(contentDetails%%2Csnippet)&key=%s", id, key)
+	api := fmt.Sprintf("%s/videos?part=contentDetails%%2Csnippet&id=%s&fields=items(contentDetails%%2Csnippet)&key=%s", p.baseURL, url.QueryEscape(id), url.QueryEscape(p.token))
 
 	var videos ytVideos
-	if err := internal.GetJSON(api, &videos); err != nil {
-		return "", ""
+	if err := internal.GetJSON(ctx, p.http, api, &videos); err != nil {
+		return "", "", err
 	}
 
 	// Make sure we found a video
 	if len(videos.Items) < 1 {
-		return "", ""
+		return "", "", nil
 	}
 
 	v := videos.Items[0]
@@ -120,13 +241,13 @@ func getVideo(id string, key string) (time string, title string) {
 
 	switch v.Snippet.LiveBroadcastContent {
 	case "live", "upcoming":
-		return strings.Title(v.Snippet.LiveBroadcastContent), title
+		return strings.Title(v.Snippet.LiveBroadcastContent), title, nil
 	}
 
 	// Convert duration from ISO8601
 	d, err := duration.FromString(v.ContentDetails.Duration)
 	if err != nil {
-		return "", ""
+		return "", "", err
 	}
 
 	var dr string
@@ -141,5 +262,5 @@ func getVideo(id string, key string) (time string, title string) {
 		dr = fmt.Sprintf("%02d:%02d", d.Minutes, d.Seconds)
 	}
 
-	return dr, title
+	return dr, title, nil
 }
@@ -0,0 +1,157 @@
+package url
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestYoutubeProvider(t *testing.T) {
+	server := newFixtureServer(t, "youtube", map[string]string{
+		"/videos": "videos.json",
+	})
+
+	p := NewYoutubeProvider(YoutubeOptions{
+		Token:      "test-token",
+		HTTPClient: http.DefaultClient,
+		BaseURL:    server.URL,
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "watch",
+			url:   "https://youtube.com/watch?v=dQw4w9WgXcQ",
+			reply: "[YouTube] 03:33 ~ Rick Astley - Never Gonna Give You Up (Official Music Video) by Rick Astley",
+			fields: map[string]string{
+				"id":       "dQw4w9WgXcQ",
+				"duration": "03:33",
+			},
+		},
+		{
+			name:  "short",
+			url:   "https://youtu.be/dQw4w9WgXcQ",
+			reply: "[YouTube] 03:33 ~ Rick Astley - Never Gonna Give You Up (Official Music Video) by Rick Astley",
+		},
+	})
+
+	empty := newFixtureServer(t, "youtube", map[string]string{
+		"/videos": "empty.json",
+	})
+
+	p = NewYoutubeProvider(YoutubeOptions{
+		Token:      "test-token",
+		HTTPClient: http.DefaultClient,
+		BaseURL:    empty.URL,
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name: "no video id",
+			url:  "https://youtube.com",
+		},
+		{
+			name: "missing video",
+			url:  "https://youtube.com/watch?v=missing",
+		},
+	})
+
+	broken := newFixtureServer(t, "youtube", map[string]string{
+		"/videos": "500:",
+	})
+
+	p = NewYoutubeProvider(YoutubeOptions{
+		Token:      "test-token",
+		HTTPClient: http.DefaultClient,
+		BaseURL:    broken.URL,
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:   "api error",
+			url:    "https://youtube.com/watch?v=dQw4w9WgXcQ",
+			failed: true,
+		},
+	})
+}
+
+func TestYoutubeProviderOEmbed(t *testing.T) {
+	server := newFixtureServer(t, "youtube", map[string]string{
+		"/oembed": "oembed.json",
+	})
+
+	// Without a token, videos are looked up with oEmbed rather than the API.
+	p := NewYoutubeProvider(YoutubeOptions{
+		HTTPClient: http.DefaultClient,
+		BaseURL:    server.URL + "/unused",
+		OEmbedURL:  server.URL + "/oembed",
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name:  "watch",
+			url:   "https://youtube.com/watch?v=dQw4w9WgXcQ",
+			reply: "[YouTube] Rick Astley - Never Gonna Give You Up (Official Music Video) by Rick Astley",
+			fields: map[string]string{
+				"id": "dQw4w9WgXcQ",
+			},
+		},
+	})
+
+	missing := newFixtureServer(t, "youtube", map[string]string{})
+
+	p = NewYoutubeProvider(YoutubeOptions{
+		HTTPClient: http.DefaultClient,
+		OEmbedURL:  missing.URL + "/oembed",
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{
+			name: "missing video",
+			url:  "https://youtu.be/missing",
+		},
+	})
+
+	// Pages which aren't videos aren't looked up at all, so they don't show
+	// up as failures.
+	broken := newFixtureServer(t, "youtube", map[string]string{
+		"/oembed": "500:",
+	})
+
+	p = NewYoutubeProvider(YoutubeOptions{
+		HTTPClient: http.DefaultClient,
+		OEmbedURL:  broken.URL + "/oembed",
+	})
+
+	runURLTests(t, p, []urlTestCase{
+		{name: "channel", url: "https://youtube.com/@rickastley"},
+		{name: "channel id", url: "https://youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw"},
+		{name: "playlist", url: "https://youtube.com/playlist?list=PL1234"},
+		{name: "search", url: "https://youtube.com/results?search_query=rick"},
+		{name: "home", url: "https://youtube.com/"},
+		{name: "short video", url: "https://youtube.com/shorts/dQw4w9WgXcQ", failed: true},
+	})
+}
+
+func TestYoutubeVideoID(t *testing.T) {
+	var tests = []struct {
+		url string
+		id  string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&list=RD", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtube.com/shorts/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtube.com/embed/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtube.com/live/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", ""},
+		{"https://youtube.com/@rickastley", ""},
+		{"https://youtube.com/playlist?list=PL1234", ""},
+		{"https://youtube.com/results?search_query=rick", ""},
+		{"https://youtu.be", ""},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.id, youtubeVideoID(mustParseURL(t, test.url)), test.url)
+	}
+}
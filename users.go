@@ -0,0 +1,119 @@
+package url
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+)
+
+// optOutBucket is the Store bucket used to remember users who opted out of
+// link previews.
+const optOutBucket = "optouts"
+
+// optOut records when a user opted out.
+type optOut struct {
+	Time time.Time
+}
+
+// userFilter decides whose links are looked up. Users can be ignored in the
+// config, or opt themselves out with the url command.
+type userFilter struct {
+	// ignoredIDs and ignoredNames come from the config. Names are lowercase.
+	ignoredIDs   map[string]bool
+	ignoredNames map[string]bool
+
+	store *Store
+
+	lock    sync.RWMutex
+	optOuts map[string]bool
+}
+
+func newUserFilter(ignored []string, store *Store) (*userFilter, error) {
+	ret := &userFilter{
+		ignoredIDs:   make(map[string]bool),
+		ignoredNames: make(map[string]bool),
+		store:        store,
+		optOuts:      make(map[string]bool),
+	}
+
+	// There's no reliable way to tell IDs and names apart, so every entry is
+	// checked as both.
+	for _, user := range ignored {
+		ret.ignoredIDs[user] = true
+		ret.ignoredNames[strings.ToLower(user)] = true
+	}
+
+	if store == nil {
+		return ret, nil
+	}
+
+	err := store.forEach(optOutBucket, func(userID string, data []byte) error {
+		var entry optOut
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Ignoring invalid opt-out for %s: %s", userID, err)
+			return nil
+		}
+
+		ret.optOuts[userID] = true
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// ignored returns true if links from the given user shouldn't be looked up.
+func (f *userFilter) ignored(user *pb.User) bool {
+	if user == nil {
+		return false
+	}
+
+	if f.ignoredIDs[user.GetId()] || f.ignoredNames[strings.ToLower(user.GetDisplayName())] {
+		return true
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.optOuts[user.GetId()]
+}
+
+// canSave returns true if opt-outs are saved, so they survive restarts.
+func (f *userFilter) canSave() bool {
+	return f.store != nil
+}
+
+// setOptOut records whether a user has opted out, persisting it if there's
+// a store.
+func (f *userFilter) setOptOut(userID string, optedOut bool, now time.Time) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.store != nil {
+		var err error
+		if optedOut {
+			err = f.store.put(optOutBucket, userID, optOut{Time: now})
+		} else {
+			err = f.store.delete(optOutBucket, userID)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if optedOut {
+		f.optOuts[userID] = true
+	} else {
+		delete(f.optOuts, userID)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package url
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seabird-chat/seabird-go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserFilter(t *testing.T) {
+	store := newTestStore(t)
+
+	filter, err := newUserFilter([]string{"irc://test/bot", "OtherBot"}, store)
+	require.NoError(t, err)
+
+	require.True(t, filter.ignored(&pb.User{Id: "irc://test/bot", DisplayName: "bot"}))
+	require.True(t, filter.ignored(&pb.User{Id: "irc://test/other", DisplayName: "otherbot"}))
+	require.False(t, filter.ignored(testSource.GetUser()))
+	require.False(t, filter.ignored(nil))
+
+	require.NoError(t, filter.setOptOut(testSource.GetUser().GetId(), true, time.Now()))
+	require.True(t, filter.ignored(testSource.GetUser()))
+
+	// Opt-outs are kept across restarts.
+	filter, err = newUserFilter(nil, store)
+	require.NoError(t, err)
+	require.True(t, filter.ignored(testSource.GetUser()))
+
+	require.NoError(t, filter.setOptOut(testSource.GetUser().GetId(), false, time.Now()))
+	require.False(t, filter.ignored(testSource.GetUser()))
+
+	filter, err = newUserFilter(nil, store)
+	require.NoError(t, err)
+	require.False(t, filter.ignored(testSource.GetUser()))
+}
+
+func TestURLCommandOptOut(t *testing.T) {
+	c, fake := newTestClient(t)
+	p := &testProvider{host: "example.com"}
+	c.Register(p)
+
+	message := &pb.Event{
+		Inner: &pb.Event_Message{Message: &pb.MessageEvent{
+			Source: testSource,
+			Text:   "https://example.com/handled",
+		}},
+	}
+
+	// Opting out is refused if it can't be saved.
+	_, err := c.urlCommand(testSource, "optout")
+	require.EqualError(t, err, "Opting out isn't available, since there's nowhere to save your preference")
+
+	c.users, err = newUserFilter(nil, newTestStore(t))
+	require.NoError(t, err)
+
+	// Opting out doesn't need to be an admin.
+	reply, err := c.urlCommand(testSource, "optout")
+	require.NoError(t, err)
+	require.Contains(t, reply, "won't be previewed")
+
+	c.handleEvent(message)
+	c.inFlight.Wait()
+	require.Zero(t, p.calls.Load())
+
+	_, err = c.urlCommand(testSource, "optout now")
+	require.EqualError(t, err, urlUsage)
+
+	_, err = c.urlCommand(testSource, "optin")
+	require.NoError(t, err)
+
+	c.handleEvent(message)
+	c.inFlight.Wait()
+	require.Equal(t, []string{"[Test] handled"}, fake.Messages())
+}